@@ -36,6 +36,10 @@ func (p *Plugin) OnActivate() error {
 		return errors.Wrap(err, "failed to register commands")
 	}
 
+	if err := p.loadStampImages(); err != nil {
+		return errors.Wrap(err, "failed to load stamp images")
+	}
+
 	return nil
 }
 
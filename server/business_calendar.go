@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// businessWeekDays 週の開始曜日から数えた営業日の日数（残りは週末）
+const businessWeekDays = 5
+
+// businessCalendar チームの営業日の定義
+type businessCalendar struct {
+	weekStart time.Weekday
+	holidays  []packDate
+}
+
+// getWeekStart 指定の日を含む週の開始日を求める
+func (b *businessCalendar) getWeekStart(day time.Time) time.Time {
+	offset := (int(day.Weekday()) - int(b.weekStart) + 7) % 7
+	return day.AddDate(0, 0, -1*offset)
+}
+
+// isBusinessDay 営業日か確認する（週の開始曜日から５日間のうち休日でない日）
+func (b *businessCalendar) isBusinessDay(day time.Time) bool {
+	if (int(day.Weekday())-int(b.weekStart)+7)%7 >= businessWeekDays {
+		return false
+	}
+	for _, holiday := range b.holidays {
+		if holiday.compare(day) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// countBusinessDays 期間中の営業日の日数を数える
+func (b *businessCalendar) countBusinessDays(from time.Time, to time.Time) int {
+	count := 0
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if b.isBusinessDay(day) {
+			count++
+		}
+	}
+	return count
+}
+
+// getCalendar チームの営業日の定義を取得する（チーム毎の定義が無ければ全体の定義）
+func (c *configuration) getCalendar(teamID string) *businessCalendar {
+	if calendar, ok := c.teamCalendars[teamID]; ok {
+		return calendar
+	}
+	return c.calendar
+}
+
+// parseWeekday 曜日の英語名（sunday, monday, ...）を読み込む
+func parseWeekday(value string) (time.Weekday, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if strings.ToLower(weekday.String()) == value {
+			return weekday, nil
+		}
+	}
+	return time.Sunday, errors.Errorf("有効な曜日ではありません。（%s）", value)
+}
+
+// parseHolidays 休日の一覧を読み込む
+// 休日は「YYYY/MM/DD」または毎年繰り返す「MM/DD」で、「日付|名前」の書式で名前を付けられる
+func parseHolidays(values []string) ([]packDate, error) {
+	holidays := []packDate{}
+	for _, value := range values {
+		value = strings.Split(value, "|")[0]
+		if strings.TrimSpace(value) == "" {
+			continue //空行はスキップ
+		}
+		holiday, err := parsePackDate(value)
+		if err != nil {
+			return nil, err
+		}
+		holidays = append(holidays, holiday)
+	}
+	return holidays, nil
+}
+
+// readBusinessCalendars 週の開始曜日と休日を読み込む
+// チーム毎の定義の１行の書式は「チーム名|週の開始曜日|休日,...」で、省略した項目は全体の定義を使う
+func (p *Plugin) readBusinessCalendars(configuration *configuration) error {
+	weekStart := time.Monday
+	if strings.TrimSpace(configuration.WeekStartDay) != "" {
+		var err error
+		if weekStart, err = parseWeekday(configuration.WeekStartDay); err != nil {
+			return errors.Wrap(err, "週の開始曜日")
+		}
+	}
+	holidays, err := parseHolidays(strings.Split(configuration.Holidays, "\n"))
+	if err != nil {
+		return errors.Wrap(err, "休日")
+	}
+	configuration.calendar = &businessCalendar{
+		weekStart: weekStart,
+		holidays:  holidays,
+	}
+
+	configuration.teamCalendars = make(map[string]*businessCalendar)
+	for _, line := range strings.Split(configuration.TeamCalendars, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue //空行はスキップ
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) != 3 {
+			return errors.Errorf("チーム毎の営業日は「チーム名|週の開始曜日|休日,...」の書式で入力してください。（%s）", line)
+		}
+		teamName := strings.TrimSpace(fields[0])
+		team, appErr := p.API.GetTeamByName(teamName)
+		if appErr != nil {
+			return errors.Errorf("チームが見つかりません。（%s）", teamName)
+		}
+
+		calendar := *configuration.calendar
+		if strings.TrimSpace(fields[1]) != "" {
+			if calendar.weekStart, err = parseWeekday(fields[1]); err != nil {
+				return errors.Wrapf(err, "チーム %s の週の開始曜日", teamName)
+			}
+		}
+		if teamHolidays := splitList(fields[2]); len(teamHolidays) > 0 {
+			if calendar.holidays, err = parseHolidays(teamHolidays); err != nil {
+				return errors.Wrapf(err, "チーム %s の休日", teamName)
+			}
+		}
+		configuration.teamCalendars[team.Id] = &calendar
+	}
+
+	return nil
+}
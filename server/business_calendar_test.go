@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusinessCalendarGetWeekStart(t *testing.T) {
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2019, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		weekStart time.Weekday
+		day       time.Time
+		want      time.Time
+	}{
+		{name: "月曜始まり：週の途中", weekStart: time.Monday, day: date(time.May, 15), want: date(time.May, 13)},
+		{name: "月曜始まり：開始日", weekStart: time.Monday, day: date(time.May, 13), want: date(time.May, 13)},
+		{name: "月曜始まり：日曜", weekStart: time.Monday, day: date(time.May, 19), want: date(time.May, 13)},
+		{name: "日曜始まり：日曜", weekStart: time.Sunday, day: date(time.May, 19), want: date(time.May, 19)},
+		{name: "日曜始まり：土曜", weekStart: time.Sunday, day: date(time.May, 18), want: date(time.May, 12)},
+		{name: "月を跨ぐ週", weekStart: time.Monday, day: date(time.May, 2), want: date(time.April, 29)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calendar := &businessCalendar{weekStart: test.weekStart}
+			if got := calendar.getWeekStart(test.day); !got.Equal(test.want) {
+				t.Errorf("getWeekStart(%v) = %v, want %v", test.day, got, test.want)
+			}
+		})
+	}
+}
+
+func TestBusinessCalendarCountBusinessDays(t *testing.T) {
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2019, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	goldenWeek := []packDate{
+		{year: 2019, month: 5, day: 1},
+		{year: 2019, month: 5, day: 2},
+		{year: 2019, month: 5, day: 3},
+		{year: 2019, month: 5, day: 6},
+	}
+
+	tests := []struct {
+		name      string
+		weekStart time.Weekday
+		holidays  []packDate
+		from      time.Time
+		to        time.Time
+		want      int
+	}{
+		{name: "月曜始まりの１週間", weekStart: time.Monday, from: date(time.May, 13), to: date(time.May, 20), want: 5},
+		{name: "週末のみ", weekStart: time.Monday, from: date(time.May, 18), to: date(time.May, 20), want: 0},
+		{name: "日曜始まりの１週間", weekStart: time.Sunday, from: date(time.May, 12), to: date(time.May, 19), want: 5},
+		{name: "日曜始まりの金土は週末", weekStart: time.Sunday, from: date(time.May, 17), to: date(time.May, 19), want: 0},
+		{name: "年を指定した休日", weekStart: time.Monday, holidays: []packDate{{year: 2019, month: 5, day: 15}}, from: date(time.May, 13), to: date(time.May, 20), want: 4},
+		{name: "毎年の休日", weekStart: time.Monday, holidays: []packDate{{month: 5, day: 15}}, from: date(time.May, 13), to: date(time.May, 20), want: 4},
+		{name: "別の年の休日は数えない", weekStart: time.Monday, holidays: []packDate{{year: 2020, month: 5, day: 15}}, from: date(time.May, 13), to: date(time.May, 20), want: 5},
+		{name: "週末の休日は重複して引かない", weekStart: time.Monday, holidays: []packDate{{month: 5, day: 18}}, from: date(time.May, 13), to: date(time.May, 20), want: 5},
+		{name: "１ヶ月", weekStart: time.Monday, from: date(time.May, 1), to: date(time.June, 1), want: 23},
+		{name: "１ヶ月（連休あり）", weekStart: time.Monday, holidays: goldenWeek, from: date(time.May, 1), to: date(time.June, 1), want: 19},
+		{name: "空の期間", weekStart: time.Monday, from: date(time.May, 13), to: date(time.May, 13), want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calendar := &businessCalendar{weekStart: test.weekStart, holidays: test.holidays}
+			if got := calendar.countBusinessDays(test.from, test.to); got != test.want {
+				t.Errorf("countBusinessDays(%v, %v) = %d, want %d", test.from, test.to, got, test.want)
+			}
+		})
+	}
+}
@@ -10,8 +10,10 @@ import (
 )
 
 const (
-	commandPeerPost   = "peer"
-	commandPeerReport = "peer-report"
+	commandPeerPost    = "peer"
+	commandPeerReport  = "peer-report"
+	commandPeerHashtag = "peer-hashtag"
+	commandPeerStamp   = "peer-stamp"
 )
 
 func (p *Plugin) registerCommands() error {
@@ -31,7 +33,7 @@ func (p *Plugin) registerCommands() error {
 	err = p.API.RegisterCommand(&model.Command{
 		Trigger:          commandPeerReport,
 		AutoComplete:     true,
-		AutoCompleteHint: "[YYYY/MM/DD]",
+		AutoCompleteHint: "[me | @ユーザ名] [YYYY/MM/DD [YYYY/MM/DD] | 期間] [--post] [--dm] [--top N] | rebuild",
 		AutoCompleteDesc: "ピア投稿の各種ランキングを見ることが出来ます",
 		DisplayName:      "ピア投稿レポート コマンド",
 	})
@@ -39,6 +41,28 @@ func (p *Plugin) registerCommands() error {
 		return errors.Wrapf(err, "failed to register %s command", commandPeerReport)
 	}
 
+	err = p.API.RegisterCommand(&model.Command{
+		Trigger:          commandPeerHashtag,
+		AutoComplete:     true,
+		AutoCompleteHint: "migrate",
+		AutoCompleteDesc: "過去のピア投稿のハッシュタグを正式なハッシュタグに書き換えます（システム管理者のみ）",
+		DisplayName:      "ピア投稿ハッシュタグ管理 コマンド",
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to register %s command", commandPeerHashtag)
+	}
+
+	err = p.API.RegisterCommand(&model.Command{
+		Trigger:          commandPeerStamp,
+		AutoComplete:     true,
+		AutoCompleteHint: "[add|remove|list] [ラベル]",
+		AutoCompleteDesc: "ピア投稿のスタンプを管理します（add, removeはシステム管理者のみ）",
+		DisplayName:      "ピア投稿スタンプ管理 コマンド",
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to register %s command", commandPeerStamp)
+	}
+
 	return nil
 }
 
@@ -58,6 +82,16 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 			plugin: p,
 		}
 		response, appError = uc.execute(args)
+	case commandPeerHashtag:
+		uc := peerHashtagUsecase{
+			plugin: p,
+		}
+		response, appError = uc.execute(args)
+	case commandPeerStamp:
+		uc := peerStampUsecase{
+			plugin: p,
+		}
+		response, appError = uc.execute(args)
 	default:
 		response, appError = nil, nil
 	}
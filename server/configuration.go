@@ -1,17 +1,57 @@
 package main
 
 import (
+	"time"
+
 	"github.com/mattermost/mattermost-server/v5/model"
 )
 
 type configuration struct {
-	Hashtags string
+	Hashtags             string
+	TeamHashtags         string
+	HashtagAliases       string
+	MinimumMessageLength string
+	BannedWords          string
+	MinimumHashtags      string
+	MaximumHashtags      string
+	UseCustomEmojiStamps bool
+	StampPacks           string
+	FiscalYearStartMonth string
+	WeekStartDay         string
+	Holidays             string
+	TeamCalendars        string
+	ReportViewers        string
 
 	channelIds map[string]string
 
 	bot *model.Bot
 
+	hashtags []*hashtagDefinition
+
 	hashtagOptions []*model.PostActionOptions
+
+	teamHashtags       map[string][]*hashtagDefinition
+	teamHashtagOptions map[string][]*model.PostActionOptions
+
+	hashtagAliases map[string]string
+
+	minimumHashtags int
+	maximumHashtags int
+
+	stampPacks []*stampPack
+
+	minimumMessageLength int
+
+	bannedWords []string
+
+	fiscalYearStartMonth time.Month
+
+	calendar      *businessCalendar
+	teamCalendars map[string]*businessCalendar
+
+	reportViewerIDs []string
+
+	dialogStateSecret []byte
 }
 
 func (c *configuration) Clone() *configuration {
@@ -19,11 +59,19 @@ func (c *configuration) Clone() *configuration {
 
 	configuration.bot = c.bot
 
+	configuration.dialogStateSecret = c.dialogStateSecret
+
 	configuration.channelIds = make(map[string]string)
 	for key, value := range c.channelIds {
 		configuration.channelIds[key] = value
 	}
 
+	configuration.hashtags = []*hashtagDefinition{}
+	for _, hashtag := range c.hashtags {
+		h := *hashtag
+		configuration.hashtags = append(configuration.hashtags, &h)
+	}
+
 	configuration.hashtagOptions = []*model.PostActionOptions{}
 	for _, option := range c.hashtagOptions {
 		o := model.PostActionOptions{
@@ -33,9 +81,105 @@ func (c *configuration) Clone() *configuration {
 		configuration.hashtagOptions = append(configuration.hashtagOptions, &o)
 	}
 
+	configuration.teamHashtags = make(map[string][]*hashtagDefinition)
+	for teamID, hashtags := range c.teamHashtags {
+		configuration.teamHashtags[teamID] = append([]*hashtagDefinition{}, hashtags...)
+	}
+	configuration.teamHashtagOptions = make(map[string][]*model.PostActionOptions)
+	for teamID, options := range c.teamHashtagOptions {
+		configuration.teamHashtagOptions[teamID] = append([]*model.PostActionOptions{}, options...)
+	}
+
+	configuration.hashtagAliases = make(map[string]string)
+	for key, value := range c.hashtagAliases {
+		configuration.hashtagAliases[key] = value
+	}
+
+	configuration.minimumHashtags = c.minimumHashtags
+	configuration.maximumHashtags = c.maximumHashtags
+
+	configuration.stampPacks = append([]*stampPack{}, c.stampPacks...)
+
+	configuration.minimumMessageLength = c.minimumMessageLength
+
+	configuration.bannedWords = append([]string{}, c.bannedWords...)
+
+	configuration.fiscalYearStartMonth = c.fiscalYearStartMonth
+
+	configuration.calendar = c.calendar
+	configuration.teamCalendars = make(map[string]*businessCalendar)
+	for teamID, calendar := range c.teamCalendars {
+		configuration.teamCalendars[teamID] = calendar
+	}
+
+	configuration.reportViewerIDs = append([]string{}, c.reportViewerIDs...)
+
 	return &configuration
 }
 
+// hashtagDefinition チームハッシュタグ（会社の価値観）の定義
+type hashtagDefinition struct {
+	name        string
+	description string
+	weight      float64
+	retired     bool
+}
+
+// getHashtags チームのハッシュタグを取得する（チーム毎の定義が無ければ全体の定義）
+func (c *configuration) getHashtags(teamID string) []*hashtagDefinition {
+	if hashtags, ok := c.teamHashtags[teamID]; ok {
+		return hashtags
+	}
+	return c.hashtags
+}
+
+// getHashtagOptions チームのダイアログで選択できるハッシュタグを取得する
+func (c *configuration) getHashtagOptions(teamID string) []*model.PostActionOptions {
+	if options, ok := c.teamHashtagOptions[teamID]; ok {
+		return options
+	}
+	return c.hashtagOptions
+}
+
+// findHashtag チームで定義済みのハッシュタグを取得する（廃止済みを含む）
+func (c *configuration) findHashtag(teamID string, name string) *hashtagDefinition {
+	for _, hashtag := range c.getHashtags(teamID) {
+		if hashtag.name == name {
+			return hashtag
+		}
+	}
+	return nil
+}
+
+// isDefinedHashtag 全体またはいずれかのチームで定義済みのハッシュタグか確認する
+func (c *configuration) isDefinedHashtag(name string) bool {
+	if c.findHashtag("", name) != nil {
+		return true
+	}
+	for teamID := range c.teamHashtags {
+		if c.findHashtag(teamID, name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// getCanonicalHashtag 別名を正式なハッシュタグに変換する（別名でなければそのまま）
+func (c *configuration) getCanonicalHashtag(name string) string {
+	if canonical, ok := c.hashtagAliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// getHashtagWeight チームでのハッシュタグの重みを取得する（未定義のハッシュタグは1）
+func (c *configuration) getHashtagWeight(teamID string, name string) float64 {
+	if hashtag := c.findHashtag(teamID, name); hashtag != nil {
+		return hashtag.weight
+	}
+	return 1
+}
+
 func (p *Plugin) getConfiguration() *configuration {
 	p.configurationLock.RLock()
 	defer p.configurationLock.RUnlock()
@@ -1,8 +1,11 @@
 package main
 
 import (
-	"github.com/pkg/errors"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 )
@@ -36,6 +39,34 @@ func (p *Plugin) OnConfigurationChange() error {
 		return error
 	}
 
+	if error := p.readTeamHashtags(configuration); error != nil {
+		return error
+	}
+
+	if error := p.readHashtagAliases(configuration); error != nil {
+		return error
+	}
+
+	if error := p.readStampPacks(configuration); error != nil {
+		return error
+	}
+
+	if error := p.readMessageRules(configuration); error != nil {
+		return error
+	}
+
+	if error := p.readReportSettings(configuration); error != nil {
+		return error
+	}
+
+	if error := p.readBusinessCalendars(configuration); error != nil {
+		return error
+	}
+
+	if error := p.ensureDialogStateSecret(configuration); error != nil {
+		return error
+	}
+
 	p.setConfiguration(configuration)
 
 	return nil
@@ -91,25 +122,209 @@ func (p *Plugin) ensureChannels(configuration *configuration) error {
 
 	return nil
 }
-func (p *Plugin) readHashtags(configuration *configuration) error {
-	configuration.hashtagOptions = []*model.PostActionOptions{}
 
+// readHashtags チームハッシュタグを読み込む
+func (p *Plugin) readHashtags(configuration *configuration) error {
 	if configuration.Hashtags == "" {
 		return errors.New("チームハッシュタグは必須入力です。")
 	}
-	tags := strings.Split(configuration.Hashtags, "\n")
-	for _, tag := range tags {
-		if tag == "" {
+	hashtags, options, err := parseHashtags(configuration.Hashtags)
+	if err != nil {
+		return err
+	}
+	configuration.hashtags = hashtags
+	configuration.hashtagOptions = options
+
+	//ダイアログで選択できるハッシュタグの個数
+	if configuration.minimumHashtags, err = readIntSetting(configuration.MinimumHashtags, 1); err != nil {
+		return errors.New("ハッシュタグの最小数は数値で入力してください。")
+	}
+	if configuration.maximumHashtags, err = readIntSetting(configuration.MaximumHashtags, 2); err != nil {
+		return errors.New("ハッシュタグの最大数は数値で入力してください。")
+	}
+	if configuration.minimumHashtags < 0 || configuration.maximumHashtags < 1 ||
+		configuration.maximumHashtags > dialogHashtagMaxCount || configuration.minimumHashtags > configuration.maximumHashtags {
+		return errors.Errorf("ハッシュタグの個数は 0 <= 最小数 <= 最大数 <= %d の範囲で入力してください。", dialogHashtagMaxCount)
+	}
+
+	return nil
+}
+
+// readTeamHashtags チーム毎のハッシュタグを読み込む
+// 「[チーム名]」の行に続けて、そのチームのハッシュタグをチームハッシュタグと同じ書式で入力する
+func (p *Plugin) readTeamHashtags(configuration *configuration) error {
+	configuration.teamHashtags = make(map[string][]*hashtagDefinition)
+	configuration.teamHashtagOptions = make(map[string][]*model.PostActionOptions)
+
+	teamName := ""
+	blocks := make(map[string][]string)
+	for _, line := range strings.Split(configuration.TeamHashtags, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			teamName = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			blocks[teamName] = []string{}
+			continue
+		}
+		if trimmed == "" {
+			continue //空行はスキップ
+		}
+		if teamName == "" {
+			return errors.Errorf("チーム毎のハッシュタグは「[チーム名]」の行に続けて入力してください。（%s）", line)
+		}
+		blocks[teamName] = append(blocks[teamName], line)
+	}
+
+	for teamName, lines := range blocks {
+		team, appErr := p.API.GetTeamByName(teamName)
+		if appErr != nil {
+			return errors.Errorf("チームが見つかりません。（%s）", teamName)
+		}
+		hashtags, options, err := parseHashtags(strings.Join(lines, "\n"))
+		if err != nil {
+			return errors.Wrapf(err, "チーム %s のハッシュタグ", teamName)
+		}
+		configuration.teamHashtags[team.Id] = hashtags
+		configuration.teamHashtagOptions[team.Id] = options
+	}
+
+	return nil
+}
+
+// parseHashtags ハッシュタグの定義を読み込む
+// １行の書式は「ハッシュタグ|説明|重み|retired」で、説明以降は省略可能
+func parseHashtags(text string) ([]*hashtagDefinition, []*model.PostActionOptions, error) {
+	hashtags := []*hashtagDefinition{}
+	options := []*model.PostActionOptions{}
+
+	lines := strings.Split(text, "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
 			continue //空行はスキップ
 		}
+		fields := strings.Split(line, "|")
+
+		tag := fields[0]
 		tag = strings.ReplaceAll(tag, " ", "") //スペースを削除
 		tag = strings.ReplaceAll(tag, "#", "") //#を削除
+		if tag == "" {
+			return nil, nil, errors.Errorf("ハッシュタグが入力されていません。（%s）", line)
+		}
+		definition := hashtagDefinition{
+			name:   "#" + tag,
+			weight: 1,
+		}
+		if len(fields) > 1 {
+			definition.description = strings.TrimSpace(fields[1])
+		}
+		if len(fields) > 2 && strings.TrimSpace(fields[2]) != "" {
+			weight, err := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+			if err != nil || weight < 0 {
+				return nil, nil, errors.Errorf("ハッシュタグの重みは0以上の数値で入力してください。（%s）", line)
+			}
+			definition.weight = weight
+		}
+		if len(fields) > 3 {
+			switch strings.TrimSpace(fields[3]) {
+			case "", "active":
+			case "retired":
+				definition.retired = true
+			default:
+				return nil, nil, errors.Errorf("ハッシュタグの状態は active または retired で入力してください。（%s）", line)
+			}
+		}
 		//追加
-		configuration.hashtagOptions = append(configuration.hashtagOptions, &model.PostActionOptions{
-			Text:  "#" + tag,
-			Value: "#" + tag,
+		hashtags = append(hashtags, &definition)
+
+		//廃止済みのハッシュタグはダイアログで選択させない
+		if definition.retired {
+			continue
+		}
+		options = append(options, &model.PostActionOptions{
+			Text:  definition.name,
+			Value: definition.name,
 		})
 	}
+	if len(options) == 0 {
+		return nil, nil, errors.New("有効なチームハッシュタグを一つ以上入力してください。")
+	}
+
+	return hashtags, options, nil
+}
+
+// readHashtagAliases ハッシュタグの別名を読み込む
+// １行の書式は「別名=正式なハッシュタグ」
+func (p *Plugin) readHashtagAliases(configuration *configuration) error {
+	configuration.hashtagAliases = make(map[string]string)
+
+	for _, line := range strings.Split(configuration.HashtagAliases, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue //空行はスキップ
+		}
+		fields := strings.Split(line, "=")
+		if len(fields) != 2 {
+			return errors.Errorf("ハッシュタグの別名は「別名=正式なハッシュタグ」の書式で入力してください。（%s）", line)
+		}
+		alias := "#" + strings.ReplaceAll(strings.ReplaceAll(fields[0], " ", ""), "#", "")
+		canonical := "#" + strings.ReplaceAll(strings.ReplaceAll(fields[1], " ", ""), "#", "")
+		if !configuration.isDefinedHashtag(canonical) {
+			return errors.Errorf("別名の変換先がチームハッシュタグに登録されていません。（%s）", line)
+		}
+		if configuration.isDefinedHashtag(alias) {
+			return errors.Errorf("チームハッシュタグに登録済みのハッシュタグは別名にできません。（%s）", line)
+		}
+		configuration.hashtagAliases[alias] = canonical
+	}
+
+	return nil
+}
+
+func (p *Plugin) readMessageRules(configuration *configuration) error {
+	length, err := readIntSetting(configuration.MinimumMessageLength, 1)
+	if err != nil || length < 1 || length > dialogTextMaxLength {
+		return errors.Errorf("メッセージの最小文字数は1から%dの数値で入力してください。", dialogTextMaxLength)
+	}
+	configuration.minimumMessageLength = length
+
+	configuration.bannedWords = []string{}
+	for _, word := range strings.Split(configuration.BannedWords, "\n") {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue //空行はスキップ
+		}
+		configuration.bannedWords = append(configuration.bannedWords, word)
+	}
 
 	return nil
 }
+
+func (p *Plugin) readReportSettings(configuration *configuration) error {
+	month, err := readIntSetting(configuration.FiscalYearStartMonth, 4)
+	if err != nil || month < 1 || month > 12 {
+		return errors.New("年度の開始月は1から12の数値で入力してください。")
+	}
+	configuration.fiscalYearStartMonth = time.Month(month)
+
+	//他のユーザのレポートを表示できるユーザ
+	configuration.reportViewerIDs = []string{}
+	for _, line := range strings.Split(configuration.ReportViewers, "\n") {
+		for _, userName := range splitList(line) {
+			userName = strings.TrimPrefix(userName, "@")
+			user, appErr := p.API.GetUserByUsername(userName)
+			if appErr != nil {
+				return errors.Errorf("ユーザが見つかりません。（%s）", userName)
+			}
+			configuration.reportViewerIDs = append(configuration.reportViewerIDs, user.Id)
+		}
+	}
+
+	return nil
+}
+
+// readIntSetting 数値の設定値を読み込む（未入力の場合はデフォルト値）
+func readIntSetting(value string, defaultValue int) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	dialogStateSecretKey     = "dialog-state-secret"
+	dialogStateUsedKeyPrefix = "dialog-state-used-"
+
+	dialogStateExpiration = 60 * time.Minute
+)
+
+var (
+	errDialogStateInvalid = errors.New("invalid dialog state")
+	errDialogStateExpired = errors.New("dialog state expired")
+)
+
+// dialogState ダイアログを開いた時点の情報
+// 署名してダイアログのStateに持たせ、コールバック時に改ざんされていないことを確認する
+type dialogState struct {
+	UserID        string   `json:"user_id"`
+	TargetUserIDs []string `json:"target_user_ids"`
+	TeamID        string   `json:"team_id"`
+	IssuedAt      int64    `json:"issued_at"`
+	Nonce         string   `json:"nonce"`
+}
+
+func (p *Plugin) ensureDialogStateSecret(configuration *configuration) error {
+	secret, appErr := p.API.KVGet(dialogStateSecretKey)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get dialog state secret")
+	}
+
+	//無ければ作成（複数サーバで同時に作成した場合は先に保存された方を使う）
+	if secret == nil {
+		newSecret := make([]byte, 32)
+		if _, err := rand.Read(newSecret); err != nil {
+			return errors.Wrap(err, "failed to generate dialog state secret")
+		}
+		if _, appErr := p.API.KVCompareAndSet(dialogStateSecretKey, nil, newSecret); appErr != nil {
+			return errors.Wrap(appErr, "failed to save dialog state secret")
+		}
+		if secret, appErr = p.API.KVGet(dialogStateSecretKey); appErr != nil {
+			return errors.Wrap(appErr, "failed to get dialog state secret")
+		}
+	}
+	configuration.dialogStateSecret = secret
+
+	return nil
+}
+
+func (p *Plugin) signDialogState(state dialogState) (string, error) {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal dialog state")
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	signature := p.createDialogStateSignature(encoded)
+	return encoded + "." + signature, nil
+}
+
+func (p *Plugin) verifyDialogState(token string) (*dialogState, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errDialogStateInvalid
+	}
+
+	expected := p.createDialogStateSignature(parts[0])
+	if !hmac.Equal([]byte(expected), []byte(parts[1])) {
+		return nil, errDialogStateInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errDialogStateInvalid
+	}
+	var state dialogState
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return nil, errDialogStateInvalid
+	}
+
+	issuedAt := time.Unix(0, state.IssuedAt*int64(time.Millisecond))
+	if time.Since(issuedAt) > dialogStateExpiration {
+		return nil, errDialogStateExpired
+	}
+
+	return &state, nil
+}
+
+// consumeDialogState 同じダイアログからの二重投稿を防ぐため、使用済みとして記録する
+// 既に使用済みの場合はfalseを返す
+func (p *Plugin) consumeDialogState(state *dialogState) (bool, *model.AppError) {
+	key := dialogStateUsedKeyPrefix + state.Nonce
+	ok, appErr := p.API.KVCompareAndSet(key, nil, []byte{1})
+	if appErr != nil || !ok {
+		return false, appErr
+	}
+
+	//有効期限を過ぎた記録は不要なので期限付きで保存し直す
+	if appErr := p.API.KVSetWithExpiry(key, []byte{1}, int64(dialogStateExpiration/time.Second)); appErr != nil {
+		p.API.LogWarn("Failed to set expiry of dialog state", "err", appErr.Error())
+	}
+
+	return true, nil
+}
+
+// releaseDialogState 投稿に失敗した場合に再送信できるよう、使用済みの記録を削除する
+func (p *Plugin) releaseDialogState(state *dialogState) *model.AppError {
+	return p.API.KVDelete(dialogStateUsedKeyPrefix + state.Nonce)
+}
+
+func (p *Plugin) createDialogStateSignature(encoded string) string {
+	mac := hmac.New(sha256.New, p.getConfiguration().dialogStateSecret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
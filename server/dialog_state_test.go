@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDialogState(t *testing.T) {
+	newPlugin := func(secret string) *Plugin {
+		p := &Plugin{}
+		p.setConfiguration(&configuration{dialogStateSecret: []byte(secret)})
+		return p
+	}
+	newState := func(issuedAt time.Time) dialogState {
+		return dialogState{
+			UserID:        "user1",
+			TargetUserIDs: []string{"user2", "user3"},
+			TeamID:        "team1",
+			IssuedAt:      issuedAt.UnixNano() / int64(time.Millisecond),
+			Nonce:         "nonce1",
+		}
+	}
+	sign := func(t *testing.T, p *Plugin, state dialogState) string {
+		token, err := p.signDialogState(state)
+		if err != nil {
+			t.Fatalf("signDialogState() returned error: %v", err)
+		}
+		return token
+	}
+
+	p := newPlugin("secret")
+	now := time.Now()
+	valid := sign(t, p, newState(now))
+	other := sign(t, p, dialogState{UserID: "user9", IssuedAt: now.UnixNano() / int64(time.Millisecond)})
+	validParts := strings.Split(valid, ".")
+	otherParts := strings.Split(other, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "有効", token: valid},
+		{name: "有効期限の直前", token: sign(t, p, newState(now.Add(-dialogStateExpiration+time.Minute)))},
+		{name: "有効期限切れ", token: sign(t, p, newState(now.Add(-dialogStateExpiration-time.Minute))), wantErr: errDialogStateExpired},
+		{name: "内容の改ざん", token: otherParts[0] + "." + validParts[1], wantErr: errDialogStateInvalid},
+		{name: "署名の改ざん", token: validParts[0] + "." + otherParts[1], wantErr: errDialogStateInvalid},
+		{name: "別の秘密鍵で署名", token: sign(t, newPlugin("another"), newState(now)), wantErr: errDialogStateInvalid},
+		{name: "署名なし", token: validParts[0], wantErr: errDialogStateInvalid},
+		{name: "空", token: "", wantErr: errDialogStateInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, err := p.verifyDialogState(test.token)
+			if err != test.wantErr {
+				t.Fatalf("verifyDialogState() error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr != nil {
+				return
+			}
+			want := newState(now)
+			want.IssuedAt = state.IssuedAt
+			if !reflect.DeepEqual(*state, want) {
+				t.Errorf("verifyDialogState() = %+v, want %+v", *state, want)
+			}
+		})
+	}
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/mattermost/mattermost-server/v5/plugin"
@@ -21,7 +20,7 @@ import (
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 	if strings.HasPrefix(path, "/stamp/") {
-		p.handleStampImage(path, w)
+		p.handleStampImage(w, r)
 	} else if path == "/peer/callback" {
 		uc := peerPostUsecase{
 			plugin: p,
@@ -32,15 +31,32 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	}
 }
 
-func (p *Plugin) handleStampImage(path string, w http.ResponseWriter) {
-	imagePath := fmt.Sprintf("plugins/%s/assets%s", manifest.Id, path)
-	data, err := ioutil.ReadFile(imagePath)
-	if err == nil {
-		w.Header().Set("Content-Type", "image/png")
-		w.Write(data)
-	} else {
-		w.WriteHeader(404)
-		w.Write([]byte("404 Something went wrong - " + http.StatusText(404)))
-		p.API.LogInfo(path+" err = ", err.Error())
+func (p *Plugin) handleStampImage(w http.ResponseWriter, r *http.Request) {
+	//既知のスタンプのみ返す（パスからファイルを読むことはしない）
+	image := p.getStampImage(r.URL.Path)
+	if image == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	//サイズの指定があれば縮小したものを返す
+	if value := r.URL.Query().Get("size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || !isValidStampSize(size) {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+		image = p.getResizedStampImage(r.URL.Path, image, size)
+	}
+
+	w.Header().Set("Content-Type", image.mimeType)
+	w.Header().Set("ETag", image.etag)
+	w.Header().Set("Cache-Control", stampCacheControl)
+	if match := r.Header.Get("If-None-Match"); match != "" && match == image.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if _, err := w.Write(image.data); err != nil {
+		p.API.LogError("failed to write stamp image", "err", err.Error())
 	}
 }
@@ -1,14 +1,25 @@
 package main
 
 import (
+	"reflect"
+
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 )
 
+// ランキングの集計に使われるProp
+var peerPostPropKeys = []string{"hashtags", "from-to", "attachments", "stamp"}
+
 func (p *Plugin) MessageWillBePosted(c *plugin.Context, post *model.Post) (*model.Post, string) {
 
 	//このプラグインのPostである事が前提
 	if post.Type == "custom_peer-post" {
+		//このプラグイン以外からの投稿は受け付けない
+		if !p.isPostedByPlugin(c, post) {
+			p.API.LogWarn("Rejected peer post not created by plugin", "user_id", post.UserId)
+			return nil, "ピア投稿は /peer コマンドからのみ投稿できます。"
+		}
+
 		//ハッシュタグを追加
 		propValue, ok := post.Props["hashtags"]
 		if ok {
@@ -21,3 +32,56 @@ func (p *Plugin) MessageWillBePosted(c *plugin.Context, post *model.Post) (*mode
 
 	return post, ""
 }
+
+func (p *Plugin) MessageWillBeUpdated(c *plugin.Context, newPost, oldPost *model.Post) (*model.Post, string) {
+
+	//ピア投稿、またはピア投稿に変更しようとしている投稿のみが対象
+	if newPost.Type != "custom_peer-post" && oldPost.Type != "custom_peer-post" {
+		return newPost, ""
+	}
+
+	//このプラグインによる更新はそのまま受け付ける
+	if p.isPostedByPlugin(c, newPost) {
+		//ハッシュタグの書き換えを反映
+		if hashtags, ok := newPost.Props["hashtags"].(string); ok {
+			newPost.Hashtags = hashtags
+		}
+		return newPost, ""
+	}
+
+	//集計に影響する変更は受け付けない
+	if newPost.Type != oldPost.Type || newPost.Hashtags != oldPost.Hashtags {
+		p.API.LogWarn("Rejected peer post update not made by plugin", "post_id", oldPost.Id)
+		return nil, "ピア投稿の内容は変更できません。"
+	}
+	for _, key := range peerPostPropKeys {
+		if !reflect.DeepEqual(newPost.Props[key], oldPost.Props[key]) {
+			p.API.LogWarn("Rejected peer post update not made by plugin", "post_id", oldPost.Id)
+			return nil, "ピア投稿の内容は変更できません。"
+		}
+	}
+
+	return newPost, ""
+}
+
+// MessageHasBeenPosted ピア投稿を日毎の集計に反映する
+// 削除とリアクションを通知するフックは無いため、それらもここで前回からの差分として更新日時から反映する
+// 投稿の度に全ての投稿を読み直さないよう、ここでは差分の反映のみ行う（集計の作り直しはレポートの実行時とrebuildコマンドに任せる）
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	if post.Type != "custom_peer-post" || !p.isPostedByPlugin(c, post) {
+		return
+	}
+	if _, err := p.syncPostIndex(post.ChannelId); err != nil {
+		p.API.LogError("Failed to sync post index", "err", err.Error())
+	}
+}
+
+// isPostedByPlugin プラグインAPI経由でBotとして投稿されたものか確認する
+// プラグインAPI経由の場合はセッションが無い
+func (p *Plugin) isPostedByPlugin(c *plugin.Context, post *model.Post) bool {
+	bot := p.getConfiguration().bot
+	if bot == nil || post.UserId != bot.UserId {
+		return false
+	}
+	return c == nil || c.SessionId == ""
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+type peerHashtagUsecase struct {
+	plugin *Plugin
+}
+
+const (
+	commandPeerHashtagUsage = "** ピア投稿ハッシュタグ管理 Slash Command Help **\n\n  /peer-hashtag migrate\n\n  - 過去のピア投稿のハッシュタグを、設定の「ハッシュタグの別名」に従って正式なハッシュタグに書き換えます。\n\n  - システム管理者のみ実行できます。"
+
+	migratePostsPerPage = 200
+)
+
+func (p *peerHashtagUsecase) execute(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+
+	fields := strings.Fields(args.Command)
+	if len(fields) != 2 || fields[1] != "migrate" {
+		return p.plugin.createCommandResponse(commandPeerHashtagUsage), nil
+	}
+
+	if !p.plugin.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return p.plugin.createCommandResponse("このコマンドはシステム管理者のみ実行できます。"), nil
+	}
+
+	//全てのチームのピア投稿部屋が対象
+	configuration := p.plugin.getConfiguration()
+	updated := 0
+	for _, channelID := range configuration.channelIds {
+		count, err := p.migrateChannel(channelID)
+		updated += count
+		if err != nil {
+			p.plugin.API.LogError("Failed to migrate hashtags", "err", err.Error())
+			errorMessage := fmt.Sprintf("ハッシュタグの書き換え中にエラーが発生しました。（%d件書き換え済み）", updated)
+			return p.plugin.createCommandResponse(errorMessage), nil
+		}
+	}
+
+	return p.plugin.createCommandResponse(fmt.Sprintf("%d件のピア投稿のハッシュタグを書き換えました。", updated)), nil
+}
+
+func (p *peerHashtagUsecase) migrateChannel(channelID string) (int, *model.AppError) {
+	configuration := p.plugin.getConfiguration()
+	updated := 0
+	for page := 0; ; page++ {
+		postList, err := p.plugin.API.GetPostsForChannel(channelID, page, migratePostsPerPage)
+		if err != nil {
+			return updated, err
+		}
+		if len(postList.Order) == 0 {
+			break
+		}
+
+		for _, postID := range postList.Order {
+			post := postList.Posts[postID]
+			if post.Type != "custom_peer-post" || post.DeleteAt != 0 {
+				continue
+			}
+			oldHashtags, ok := post.Props["hashtags"].(string)
+			if !ok || oldHashtags == "" {
+				continue
+			}
+
+			//別名を正式なハッシュタグに置き換える
+			tags := []string{}
+			for _, tag := range strings.Fields(oldHashtags) {
+				tags = append(tags, configuration.getCanonicalHashtag(tag))
+			}
+			newHashtags := joinHashtags(tags)
+			if newHashtags == oldHashtags {
+				continue
+			}
+
+			//本文の末尾にあるハッシュタグの行も書き換える
+			attachments := post.Attachments()
+			for _, attachment := range attachments {
+				lines := strings.Split(attachment.Text, "\n")
+				if lines[len(lines)-1] == oldHashtags {
+					lines[len(lines)-1] = newHashtags
+					attachment.Text = strings.Join(lines, "\n")
+				}
+			}
+
+			post.AddProp("hashtags", newHashtags)
+			post.AddProp("attachments", attachments)
+			if _, err := p.plugin.API.UpdatePost(post); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+
+	return updated, nil
+}
@@ -4,108 +4,354 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
 )
 
 type peerPostUsecase struct {
 	plugin *Plugin
 }
 
+// peerPostContent ピア投稿の内容
+type peerPostContent struct {
+	userID        string
+	teamID        string
+	channelID     string
+	targetUserIDs []string
+	text          string
+	hashtags      string
+	stamp         string
+}
+
+// inlineContent インライン投稿から取り出したメッセージ、ハッシュタグ、スタンプ
+type inlineContent struct {
+	text     string
+	hashtags []string
+	stamp    string
+}
+
 const (
-	commandPeerUsage = "** ピア投稿 Slash Command Help **\n\n  /peer @ユーザ名\n\n  - 自身は指定できません。\n\n  - 複数人を指すメンションは指定できません。（ex: @all, @channel, @here）\n\n  - チーム内のメンバーのみ指定できます。"
+	commandPeerUsage = "** ピア投稿 Slash Command Help **\n\n  /peer [@ユーザ名 ...] [メッセージ #ハッシュタグ :スタンプ:]\n\n  - ユーザ名を省略した場合はダイアログ内で宛先を選択できます。\n\n  - 複数のユーザを同時に指定できます。\n\n  - メッセージを続けて入力するとダイアログを開かずに投稿します。（ex: /peer @user ありがとう！ #迅速な対応 :GJ:）\n\n  - 自身は指定できません。\n\n  - 複数人を指すメンションは指定できません。（ex: @all, @channel, @here）\n\n  - チーム内のメンバーのみ指定できます。"
 )
 const (
-	dialogElementText     = "text"
-	dialogElementStamp    = "stamp"
-	dialogElementHashtag1 = "hashtag1"
-	dialogElementHashtag2 = "hashtag2"
-	dialogElementHashtag3 = "hashtag3"
+	dialogElementTarget  = "target"
+	dialogElementText    = "text"
+	dialogElementStamp   = "stamp"
+	dialogElementHashtag = "hashtag" //hashtag1, hashtag2, ...
+
+	dialogTextMaxLength = 500
+	stampThumbnailSize  = 128
+
+	emojiStampPrefix      = "emoji:"
+	emojiImageURLPrefix   = "/api/v4/emoji/"
+	emojiStampPerPage     = 200
+	emojiStampMaxPages    = 10
+	emojiStampCacheTTL    = 5 * time.Minute
+	dialogHashtagMaxCount = 5
 )
 
 func (p *peerPostUsecase) execute(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 
 	fields := strings.Fields(args.Command)
-	if len(fields) != 2 {
-		return p.plugin.createErrorCommandResponse(commandPeerUsage), nil
+	if len(fields) < 2 {
+		//宛先が無い場合はダイアログ内で宛先を選択させる
+		return p.openDialog(args, []model.User{})
+	}
+
+	//先頭から続くメンションを宛先とし、それ以降をインライン投稿の内容とする
+	mentions := []string{}
+	contents := []string{}
+	for _, field := range fields[1:] {
+		if len(contents) == 0 && strings.HasPrefix(field, "@") {
+			mentions = append(mentions, field)
+		} else {
+			contents = append(contents, field)
+		}
+	}
+	if len(mentions) == 0 {
+		return p.plugin.createCommandResponse(commandPeerUsage), nil
+	}
+
+	//メンションからユーザを取得
+	targetUsers := []model.User{}
+	for _, mention := range mentions {
+		targetUser, response, err := p.findTargetUser(mention, args.UserId, args.TeamId)
+		if err != nil {
+			return nil, err
+		} else if response != nil {
+			return response, nil
+		}
+		//同じユーザが複数回指定された場合は一人とみなす
+		duplicated := false
+		for _, user := range targetUsers {
+			if user.Id == targetUser.Id {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			targetUsers = append(targetUsers, *targetUser)
+		}
+	}
+
+	//メンション以降に内容があればダイアログを開かずに投稿する
+	if len(contents) > 0 {
+		return p.executeInline(args, targetUsers, contents)
+	}
+
+	return p.openDialog(args, targetUsers)
+}
+
+func (p *peerPostUsecase) openDialog(args *model.CommandArgs, targetUsers []model.User) (*model.CommandResponse, *model.AppError) {
+	dialogRequest, err := p.createDialogRequest(args.TriggerId, args.UserId, args.TeamId, targetUsers)
+	if err != nil {
+		p.plugin.API.LogError("Failed to create dialog request", "err", err.Error())
+		return nil, model.NewAppError("openDialog", "peerpost.create_dialog_request.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	if apiError := p.plugin.API.OpenInteractiveDialog(dialogRequest); apiError != nil {
+		p.plugin.API.LogError("Failed to open Interactive Dialog", "err", apiError.Error())
+		return nil, apiError
+	}
+
+	return &model.CommandResponse{}, nil
+}
+
+func (p *peerPostUsecase) executeInline(args *model.CommandArgs, targetUsers []model.User, contents []string) (*model.CommandResponse, *model.AppError) {
+	configuration := p.plugin.getConfiguration()
+
+	parsed, err := parseInlineContent(contents, configuration.getHashtagOptions(args.TeamId), p.createStampOptions(args.TeamId))
+	if err != nil {
+		return p.plugin.createCommandResponse(err.Error()), nil
+	}
+
+	//ダイアログと同じ条件で妥当性を確認
+	if errorMessage := configuration.validateText(parsed.text); errorMessage != "" {
+		return p.plugin.createCommandResponse(errorMessage + "\n\n" + commandPeerUsage), nil
+	}
+	if len(parsed.hashtags) < configuration.minimumHashtags {
+		errorMessage := fmt.Sprintf("チームハッシュタグを%d個以上指定してください。\n\n%s", configuration.minimumHashtags, commandPeerUsage)
+		return p.plugin.createCommandResponse(errorMessage), nil
+	} else if len(parsed.hashtags) > configuration.maximumHashtags {
+		errorMessage := fmt.Sprintf("チームハッシュタグは%d個まで指定できます。", configuration.maximumHashtags)
+		return p.plugin.createCommandResponse(errorMessage), nil
+	}
+
+	targetUserIDs := []string{}
+	for _, targetUser := range targetUsers {
+		targetUserIDs = append(targetUserIDs, targetUser.Id)
+	}
+
+	content := peerPostContent{
+		userID:        args.UserId,
+		teamID:        args.TeamId,
+		channelID:     args.ChannelId,
+		targetUserIDs: targetUserIDs,
+		text:          parsed.text,
+		hashtags:      joinHashtags(parsed.hashtags),
+		stamp:         parsed.stamp,
+	}
+	if err := p.publish(content); err != nil {
+		return nil, err
+	}
+
+	return &model.CommandResponse{}, nil
+}
+
+// parseInlineContent インライン投稿の内容をメッセージ、ハッシュタグ、スタンプに振り分ける
+// ハッシュタグは選択肢にあるものだけを受け付け、無い場合はその理由をエラーで返す
+// スタンプは最後の :名前: だけで、該当するスタンプが無い場合や、それ以外の :名前: はメッセージとして扱う（絵文字など）
+func parseInlineContent(contents []string, hashtagOptions []*model.PostActionOptions, stampOptions []*model.PostActionOptions) (*inlineContent, error) {
+	stampIndex := -1
+	for i, content := range contents {
+		if len(content) > 2 && strings.HasPrefix(content, ":") && strings.HasSuffix(content, ":") {
+			stampIndex = i
+		}
+	}
+
+	words := []string{}
+	parsed := &inlineContent{
+		hashtags: []string{},
+	}
+	for i, content := range contents {
+		if strings.HasPrefix(content, "#") {
+			if findOptionByValue(hashtagOptions, content) == nil {
+				return nil, errors.Errorf("チームハッシュタグではありません。（%s）\n\n%s", content, commandPeerUsage)
+			}
+			if !containsString(parsed.hashtags, content) {
+				parsed.hashtags = append(parsed.hashtags, content)
+			}
+			continue
+		}
+		if i == stampIndex {
+			if option := findOptionByText(stampOptions, strings.Trim(content, ":")); option != nil {
+				parsed.stamp = option.Value
+				continue
+			}
+		}
+		words = append(words, content)
+	}
+	parsed.text = strings.Join(words, " ")
+	return parsed, nil
+}
+
+func (p *peerPostUsecase) findTargetUser(mention string, userID string, teamID string) (*model.User, *model.CommandResponse, *model.AppError) {
+	targetUser, response, err := p.findMentionedUser(mention, commandPeerUsage)
+	if err != nil || response != nil {
+		return nil, response, err
 	}
 
-	//メンションを取得
-	mention := fields[1]
+	//ユーザーの妥当性確認
+	if errorMessage, err := p.validateTargetUser(*targetUser, userID, teamID); err != nil {
+		return nil, nil, err
+	} else if errorMessage != "" {
+		return nil, p.plugin.createCommandResponse(errorMessage), nil
+	}
+
+	return targetUser, nil, nil
+}
 
+// findMentionedUser メンションからユーザを取得する
+// 見つからない場合はコマンドの使い方を添えたエラーを返す
+func (p *peerPostUsecase) findMentionedUser(mention string, usage string) (*model.User, *model.CommandResponse, *model.AppError) {
 	//メンションからユーザ名を取得
 	var userName string
 	if !strings.HasPrefix(mention, "@") {
-		return p.plugin.createErrorCommandResponse(commandPeerUsage), nil
+		return nil, p.plugin.createCommandResponse(usage), nil
 	} else if "@all" == mention || "@channel" == mention || "@here" == mention {
-		return p.plugin.createErrorCommandResponse(commandPeerUsage), nil
+		return nil, p.plugin.createCommandResponse(usage), nil
 	} else {
 		userName = string([]rune(mention))[1:]
 	}
 
-	//ユーザーの妥当性確認
-	var targetUser model.User
 	if users, err := p.plugin.API.GetUsersByUsernames([]string{userName}); err != nil {
-		return nil, err
+		return nil, nil, err
 	} else if len(users) == 0 {
-		errorMessage := fmt.Sprintf("該当ユーザーを見つけることができませんでした。（%s）\n\n%s", mention, commandPeerUsage)
-		return p.plugin.createErrorCommandResponse(errorMessage), nil
+		errorMessage := fmt.Sprintf("該当ユーザーを見つけることができませんでした。（%s）\n\n%s", mention, usage)
+		return nil, p.plugin.createCommandResponse(errorMessage), nil
 	} else if len(users) > 1 {
-		errorMessage := fmt.Sprintf("ユーザーを一人に絞り込むことが出来ませんでした。（%s）\n\n%s", mention, commandPeerUsage)
-		return p.plugin.createErrorCommandResponse(errorMessage), nil
+		errorMessage := fmt.Sprintf("ユーザーを一人に絞り込むことが出来ませんでした。（%s）\n\n%s", mention, usage)
+		return nil, p.plugin.createCommandResponse(errorMessage), nil
 	} else {
-		targetUser = *users[0]
-		if targetUser.Id == args.UserId {
-			return p.plugin.createErrorCommandResponse("自身を指定することはできません。"), nil
-		}
+		return users[0], nil, nil
 	}
+}
 
-	dialogRequest := p.createDialogRequest(args.TriggerId, targetUser)
-	if apiError := p.plugin.API.OpenInteractiveDialog(dialogRequest); apiError != nil {
-		p.plugin.API.LogError("Failed to open Interactive Dialog", "err", apiError.Error())
-		return nil, apiError
+// validateTargetUser 宛先として指定できるユーザか確認する
+// 指定できない場合はその理由を返す
+func (p *peerPostUsecase) validateTargetUser(targetUser model.User, userID string, teamID string) (string, *model.AppError) {
+	if targetUser.Id == userID {
+		return "自身を指定することはできません。", nil
+	}
+	if targetUser.IsBot {
+		return "Botを指定することはできません。", nil
+	}
+	if targetUser.DeleteAt != 0 {
+		return fmt.Sprintf("無効化されたユーザーは指定できません。（%s）", p.plugin.getUserDisplayName(targetUser)), nil
 	}
 
-	return &model.CommandResponse{}, nil
+	//チーム内のメンバーであること
+	member, err := p.plugin.API.GetTeamMember(teamID, targetUser.Id)
+	if err != nil && err.StatusCode != http.StatusNotFound {
+		return "", err
+	}
+	if member == nil || member.DeleteAt != 0 {
+		return fmt.Sprintf("チーム内のメンバーのみ指定できます。（%s）", p.plugin.getUserDisplayName(targetUser)), nil
+	}
+
+	return "", nil
 }
 
-func (p *peerPostUsecase) createDialogRequest(triggerID string, targetUser model.User) model.OpenDialogRequest {
+func (p *peerPostUsecase) createDialogRequest(triggerID string, userID string, teamID string, targetUsers []model.User) (model.OpenDialogRequest, error) {
+	displayNames := []string{}
+	targetUserIDs := []string{}
+	for _, targetUser := range targetUsers {
+		displayNames = append(displayNames, p.plugin.getUserDisplayName(targetUser))
+		targetUserIDs = append(targetUserIDs, targetUser.Id)
+	}
+
+	title := strings.Join(displayNames, " さん、") + " さんへのメッセージ"
+	elements := []model.DialogElement{}
+	if len(targetUsers) == 0 {
+		//宛先が指定されていない場合はダイアログ内で選択させる
+		title = "ピア投稿"
+		elements = append(elements, model.DialogElement{
+			DisplayName: "宛先",
+			Name:        dialogElementTarget,
+			Type:        "select",
+			DataSource:  "users",
+			Placeholder: "名前で検索できます",
+		})
+	}
+
+	//宛先とチームを署名付きでStateに持たせる
+	state, err := p.plugin.signDialogState(dialogState{
+		UserID:        userID,
+		TargetUserIDs: targetUserIDs,
+		TeamID:        teamID,
+		IssuedAt:      model.GetMillis(),
+		Nonce:         model.NewId(),
+	})
+	if err != nil {
+		return model.OpenDialogRequest{}, err
+	}
+
+	configuration := p.plugin.getConfiguration()
+	elements = append(elements, model.DialogElement{
+		DisplayName: "メッセージ",
+		Name:        dialogElementText,
+		Type:        "textarea",
+		Default:     "",
+		Placeholder: "今日の打合せの相談に乗ってくれてありがとう。\nおかげでうまくまとめることが出来たよ。",
+		MinLength:   configuration.minimumMessageLength,
+		MaxLength:   dialogTextMaxLength,
+	})
+
+	//最近選択したスタンプとハッシュタグを先頭に並べる
+	recent, appErr := p.plugin.getRecentChoices(userID)
+	if appErr != nil {
+		p.plugin.API.LogWarn("Failed to get recent choices", "err", appErr.Error())
+		recent = &recentChoices{}
+	}
+	hashtagOptions := sortOptionsByRecent(p.createHashtagOptions(teamID), recent.Hashtags)
+	stampOptions := sortOptionsByRecent(p.createStampOptions(teamID), recent.Stamps)
+
+	//設定された最大数までハッシュタグの選択欄を作り、最小数までを必須とする
+	hashtagHelpText := p.createHashtagHelpText(teamID)
+	for i := 1; i <= configuration.maximumHashtags; i++ {
+		element := model.DialogElement{
+			DisplayName: fmt.Sprintf("チームハッシュタグ%d", i),
+			Name:        hashtagElementName(i),
+			Type:        "select",
+			Options:     hashtagOptions,
+			HelpText:    hashtagHelpText,
+			Optional:    i > configuration.minimumHashtags,
+		}
+		//最後に選択したハッシュタグを初期値にする
+		if i == 1 && len(recent.Hashtags) > 0 && findOptionByValue(hashtagOptions, recent.Hashtags[0]) != nil {
+			element.Default = recent.Hashtags[0]
+		}
+		elements = append(elements, element)
+	}
+	elements = append(elements, model.DialogElement{
+		DisplayName: "スタンプ",
+		Name:        dialogElementStamp,
+		Type:        "select",
+		Options:     stampOptions,
+	})
+
 	return model.OpenDialogRequest{
 		TriggerId: triggerID,
 		URL:       p.plugin.getServerHTTPURL("/peer/callback"),
 		Dialog: model.Dialog{
-			Title: p.plugin.getUserDisplayName(targetUser) + " さんへのメッセージ",
-			Elements: []model.DialogElement{
-				{
-					DisplayName: "メッセージ",
-					Name:        dialogElementText,
-					Type:        "textarea",
-					Default:     "",
-					Placeholder: "今日の打合せの相談に乗ってくれてありがとう。\nおかげでうまくまとめることが出来たよ。",
-					MinLength:   1,
-					MaxLength:   500,
-				}, {
-					DisplayName: "チームハッシュタグ１",
-					Name:        dialogElementHashtag1,
-					Type:        "select",
-					Options:     p.createHashtagOptions(),
-				}, {
-					DisplayName: "チームハッシュタグ２",
-					Name:        dialogElementHashtag2,
-					Type:        "select",
-					Options:     p.createHashtagOptions(),
-					Optional:    true,
-				}, {
-					DisplayName: "スタンプ",
-					Name:        dialogElementStamp,
-					Type:        "select",
-					Options:     p.createStampOptions(),
-				}},
+			Title:          title,
+			Elements:       elements,
 			SubmitLabel:    "投稿する",
 			NotifyOnCancel: true,
-			State:          targetUser.Id,
+			State:          state,
 		},
-	}
+	}, nil
 }
 
 func (p *peerPostUsecase) handleDialogCallback(w http.ResponseWriter, r *http.Request) {
@@ -116,6 +362,13 @@ func (p *peerPostUsecase) handleDialogCallback(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	//認証済みのユーザ本人からの送信であること
+	if userID := r.Header.Get("Mattermost-User-Id"); userID == "" || userID != request.UserId {
+		p.plugin.API.LogWarn("Dialog submission from unauthorized user", "user_id", request.UserId)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
 	if request.Cancelled {
 		configuration := p.plugin.getConfiguration()
 		if post := p.plugin.API.SendEphemeralPost(request.UserId,
@@ -135,77 +388,262 @@ func (p *peerPostUsecase) handleDialogCallback(w http.ResponseWriter, r *http.Re
 	submission := request.Submission
 
 	text, _ := submission[dialogElementText].(string)
-	hashtags, _ := submission[dialogElementHashtag1].(string)
-	if tag, ok := submission[dialogElementHashtag2].(string); ok && tag != "" {
-		hashtags = hashtags + " " + tag
-	}
 	stamp, _ := submission[dialogElementStamp].(string)
-	stamp = p.plugin.getServerHTTPURL(stamp)
 
-	createUser, err := p.plugin.API.GetUser(request.UserId)
+	//ダイアログを開いた時点の情報が改ざんされていないこと
+	state, stateErr := p.plugin.verifyDialogState(request.State)
+	if stateErr == errDialogStateExpired {
+		p.writeSubmitDialogResponse(w, &model.SubmitDialogResponse{
+			Error: "ダイアログの有効期限が切れました。もう一度コマンドを実行してください。",
+		})
+		return
+	} else if stateErr != nil || state.UserID != request.UserId || state.TeamID != request.TeamId {
+		p.plugin.API.LogWarn("Invalid dialog state", "user_id", request.UserId)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	//入力内容の妥当性確認（エラーは項目毎に返す）
+	response := &model.SubmitDialogResponse{
+		Errors: map[string]string{},
+	}
+
+	//ダイアログ内で宛先が選択された場合はその宛先を使う
+	//（コマンドで宛先を指定した場合は、署名されたStateの宛先のみを使う）
+	targetUserIDs := state.TargetUserIDs
+	targetFromDialog := false
+	if targetUserID, ok := submission[dialogElementTarget].(string); ok && targetUserID != "" && len(state.TargetUserIDs) == 0 {
+		targetUserIDs = []string{targetUserID}
+		targetFromDialog = true
+	}
+	if len(targetUserIDs) == 0 {
+		response.Errors[dialogElementTarget] = "宛先を選択してください。"
+	}
+
+	//宛先はコマンドと同じ条件で確認する（ダイアログを開いた後にチームを抜けた場合など）
+	//無効化された直後のユーザを通さないよう、キャッシュではなく最新の状態で確認する
+	targetUsers, err := p.plugin.getFreshUsers(targetUserIDs)
 	if err != nil {
-		p.plugin.API.LogError("Failed to GetUser", "err", err.Error())
+		p.plugin.API.LogError("Failed to get users", "err", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	for _, targetUserID := range targetUserIDs {
+		errorMessage, err := p.validateTargetUser(*targetUsers[targetUserID], request.UserId, request.TeamId)
+		if err != nil {
+			p.plugin.API.LogError("Failed to validate target user", "err", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if errorMessage == "" {
+			continue
+		}
+		if targetFromDialog {
+			response.Errors[dialogElementTarget] = errorMessage
+		} else {
+			response.Error = errorMessage
+		}
+	}
 
-	targetUserID := request.State
-	targetUser, err := p.plugin.API.GetUser(targetUserID)
-	if err != nil {
-		p.plugin.API.LogError("Failed to GetUser", "err", err.Error())
+	configuration := p.plugin.getConfiguration()
+	for name, errorMessage := range configuration.validateSubmission(submission, p.createHashtagOptions(request.TeamId)) {
+		response.Errors[name] = errorMessage
+	}
+
+	if findOptionByValue(p.createStampOptions(request.TeamId), stamp) == nil {
+		response.Errors[dialogElementStamp] = "スタンプを選択してください。"
+	}
+
+	if response.Error != "" || len(response.Errors) > 0 {
+		p.writeSubmitDialogResponse(w, response)
+		return
+	}
+
+	//同じダイアログからの再送信は受け付けない
+	if ok, err := p.plugin.consumeDialogState(state); err != nil {
+		p.plugin.API.LogError("Failed to consume dialog state", "err", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	} else if !ok {
+		p.writeSubmitDialogResponse(w, &model.SubmitDialogResponse{
+			Error: "この投稿は既に送信済みです。",
+		})
+		return
+	}
+
+	content := peerPostContent{
+		userID:        request.UserId,
+		teamID:        request.TeamId,
+		channelID:     request.ChannelId,
+		targetUserIDs: targetUserIDs,
+		text:          text,
+		hashtags:      joinHashtags(configuration.submittedHashtags(submission)),
+		stamp:         stamp,
+	}
+	if err := p.publish(content); err != nil {
+		//publishがエラーを返すのは投稿前に失敗した場合のみなので、同じダイアログから再送信できるようにする
+		if releaseErr := p.plugin.releaseDialogState(state); releaseErr != nil {
+			p.plugin.API.LogError("Failed to release dialog state", "err", releaseErr.Error())
+		}
+		p.writeSubmitDialogResponse(w, &model.SubmitDialogResponse{
+			Error: "投稿に失敗しました。しばらくしてから再度お試しください。",
+		})
+		return
+	}
+}
+
+// validateSubmission ダイアログの入力内容（宛先以外）の妥当性を確認する
+// 妥当でない項目について、項目名毎にその理由を返す
+func (c *configuration) validateSubmission(submission map[string]interface{}, hashtagOptions []*model.PostActionOptions) map[string]string {
+	fieldErrors := map[string]string{}
+
+	text, _ := submission[dialogElementText].(string)
+	if errorMessage := c.validateText(text); errorMessage != "" {
+		fieldErrors[dialogElementText] = errorMessage
+	}
+
+	//ハッシュタグは設定された個数の範囲で、重複なく選択されていること
+	hashtags := []string{}
+	for i := 1; i <= c.maximumHashtags; i++ {
+		name := hashtagElementName(i)
+		tag, _ := submission[name].(string)
+		if tag == "" {
+			if i <= c.minimumHashtags {
+				fieldErrors[name] = "チームハッシュタグを選択してください。"
+			}
+			continue
+		}
+		if findOptionByValue(hashtagOptions, tag) == nil {
+			fieldErrors[name] = "チームハッシュタグではありません。"
+		} else if containsString(hashtags, tag) {
+			fieldErrors[name] = "同じハッシュタグが選択されています。"
+		}
+		hashtags = append(hashtags, tag)
+	}
+
+	return fieldErrors
+}
+
+// submittedHashtags ダイアログで選択されたハッシュタグを選択欄の順に返す
+func (c *configuration) submittedHashtags(submission map[string]interface{}) []string {
+	hashtags := []string{}
+	for i := 1; i <= c.maximumHashtags; i++ {
+		if tag, _ := submission[hashtagElementName(i)].(string); tag != "" {
+			hashtags = append(hashtags, tag)
+		}
+	}
+	return hashtags
+}
+
+// validateText メッセージの妥当性を確認する
+// 妥当でない場合はその理由を返す
+func (c *configuration) validateText(text string) string {
+	length := len([]rune(strings.TrimSpace(text)))
+	if length == 0 {
+		return "メッセージを入力してください。"
+	} else if length < c.minimumMessageLength {
+		return fmt.Sprintf("メッセージは%d文字以上で入力してください。", c.minimumMessageLength)
+	} else if length > dialogTextMaxLength {
+		return fmt.Sprintf("メッセージは%d文字以内で入力してください。", dialogTextMaxLength)
+	}
+
+	lowerText := strings.ToLower(text)
+	for _, word := range c.bannedWords {
+		if strings.Contains(lowerText, strings.ToLower(word)) {
+			return fmt.Sprintf("使用できない言葉が含まれています。（%s）", word)
+		}
+	}
+
+	return ""
+}
+
+// publish ピア投稿を所定のチャンネルにBotとして投稿する
+// ダイアログからの投稿とインライン投稿の共通処理
+func (p *peerPostUsecase) publish(content peerPostContent) *model.AppError {
+	//送信者と宛先をまとめて取得する
+	users, err := p.plugin.getUsers(content.teamID, append([]string{content.userID}, content.targetUserIDs...))
+	if err != nil {
+		p.plugin.API.LogError("Failed to get users", "err", err.Error())
+		return err
+	}
+	createUser := users[content.userID]
+
+	targetNames := []string{}
+	for _, targetUserID := range content.targetUserIDs {
+		targetNames = append(targetNames, "@"+p.plugin.getUserDisplayName(*users[targetUserID])+"さん")
 	}
 
-	message := fmt.Sprintf("@%sさんへ\n%s\n%s", targetUser.GetDisplayName(model.SHOW_NICKNAME_FULLNAME), text, hashtags)
+	message := fmt.Sprintf("%sへ\n%s\n%s", strings.Join(targetNames, "、"), content.text, content.hashtags)
 
 	configuration := p.plugin.getConfiguration()
+
+	var stampURL string
+	if strings.HasPrefix(content.stamp, emojiStampPrefix) {
+		//カスタム絵文字はサーバの画像をそのまま使う
+		emoji, err := p.plugin.API.GetEmojiByName(strings.TrimPrefix(content.stamp, emojiStampPrefix))
+		if err != nil {
+			p.plugin.API.LogError("Failed to GetEmojiByName", "err", err.Error())
+			return err
+		}
+		stampURL = fmt.Sprintf("%s%s/image", emojiImageURLPrefix, emoji.Id)
+	} else if content.stamp != "" {
+		//大きさの異なるスタンプを揃えるため、縮小したものをサムネイルにする
+		stampURL = fmt.Sprintf("%s?size=%d", p.plugin.getServerHTTPURL(content.stamp), stampThumbnailSize)
+	}
+
 	post := model.Post{
-		ChannelId: configuration.channelIds[request.TeamId],
-		//ChannelId: request.ChannelId,
+		ChannelId: configuration.channelIds[content.teamID],
+		//ChannelId: content.channelID,
 		Type:   "custom_peer-post",
 		UserId: configuration.bot.UserId,
 		Props: model.StringInterface{
-			"hashtags": hashtags,
-			"from-to":  request.UserId + " " + targetUserID,
+			"hashtags": content.hashtags,
+			"from-to":  content.userID + " " + strings.Join(content.targetUserIDs, " "),
 			"attachments": []*model.SlackAttachment{{
-				AuthorName: createUser.GetDisplayName(model.SHOW_NICKNAME_FULLNAME),
+				AuthorName: p.plugin.getUserDisplayName(*createUser),
 				AuthorIcon: p.plugin.getUserProfileImageURL(createUser.Id),
 				Text:       message,
-				ThumbURL:   stamp,
+				ThumbURL:   stampURL,
 			}},
 		},
 	}
+	if content.stamp != "" {
+		post.AddProp("stamp", content.stamp) //集計用にスタンプのIDを残す
+	}
 
 	//所定のチャンネルにBotとして投稿
 	postResult, err := p.plugin.API.CreatePost(&post)
 	if err != nil {
 		p.plugin.API.LogError("Failed to CreatePost", "err", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return err
+	}
+
+	//次回のダイアログのために選択したものを記録
+	if err := p.plugin.saveRecentChoices(content.userID, content.stamp, strings.Fields(content.hashtags)); err != nil {
+		p.plugin.API.LogWarn("Failed to save recent choices", "err", err.Error())
 	}
 
 	//コマンドを実行したチャンネルと、投稿先のチャンネルが違っている場合は
 	//完了メッセージをボットが投稿
-	if request.ChannelId != configuration.channelIds[request.TeamId] {
-		permalink, err := p.plugin.getPermanentLinkURL(request.TeamId, postResult.Id)
-		if err != nil {
-			p.plugin.API.LogError("SendEphemeralPost", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+	//（投稿は既に済んでいるため、完了メッセージの失敗はエラーにしない。再送信による重複を防ぐ）
+	if content.channelID != configuration.channelIds[content.teamID] {
+		permalink, linkErr := p.plugin.getPermanentLinkURL(content.teamID, postResult.Id)
+		if linkErr != nil {
+			p.plugin.API.LogError("Failed to get permalink", "err", linkErr.Error())
+			return nil
 		}
 		if result := p.plugin.API.SendEphemeralPost(
-			request.UserId,
+			content.userID,
 			&model.Post{
-				ChannelId: request.ChannelId,
+				ChannelId: content.channelID,
 				UserId:    configuration.bot.UserId,
 				Message:   fmt.Sprintf("[こちらに投稿しました。](%s)", permalink),
 			}); result == nil {
-			p.plugin.API.LogError("Failed to SendEphemeralPost", "err", nil)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			p.plugin.API.LogError("Failed to SendEphemeralPost", "post_id", postResult.Id)
 		}
 	}
+
+	return nil
 }
 
 func (p *peerPostUsecase) writeSubmitDialogResponse(w http.ResponseWriter, response *model.SubmitDialogResponse) {
@@ -216,7 +654,85 @@ func (p *peerPostUsecase) writeSubmitDialogResponse(w http.ResponseWriter, respo
 	}
 }
 
-func (p *peerPostUsecase) createStampOptions() []*model.PostActionOptions {
+// createStampOptions チームで現在選択できるスタンプを取得する
+// スタンプパックの期間外、対象外のチームのスタンプは除く
+func (p *peerPostUsecase) createStampOptions(teamID string) []*model.PostActionOptions {
+	configuration := p.plugin.getConfiguration()
+	now := time.Now()
+
+	options := []*model.PostActionOptions{}
+	for _, option := range p.createAllStampOptions() {
+		if configuration.isStampAvailable(option.Text, now, teamID) {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// createAllStampOptions 全てのスタンプを取得する
+func (p *peerPostUsecase) createAllStampOptions() []*model.PostActionOptions {
+	options := p.createBuiltinStampOptions()
+
+	//管理者が追加したスタンプ
+	stamps, err := p.plugin.getCustomStamps()
+	if err != nil {
+		p.plugin.API.LogError("Failed to get custom stamps", "err", err.Error())
+		return options
+	}
+	for _, stamp := range stamps {
+		options = append(options, &model.PostActionOptions{Text: stamp.Label, Value: stamp.path()})
+	}
+
+	//サーバのカスタム絵文字
+	if p.plugin.getConfiguration().UseCustomEmojiStamps {
+		options = append(options, p.createEmojiStampOptions()...)
+	}
+
+	return options
+}
+
+// createEmojiStampOptions カスタム絵文字のスタンプを取得する
+// ダイアログやレポートの度に全ページを取得しないよう、一定時間キャッシュする
+func (p *peerPostUsecase) createEmojiStampOptions() []*model.PostActionOptions {
+	p.plugin.emojiStampLock.RLock()
+	if time.Now().Before(p.plugin.emojiStampExpiresAt) {
+		options := append([]*model.PostActionOptions{}, p.plugin.emojiStampOptions...)
+		p.plugin.emojiStampLock.RUnlock()
+		return options
+	}
+	p.plugin.emojiStampLock.RUnlock()
+
+	options := p.fetchEmojiStampOptions()
+
+	p.plugin.emojiStampLock.Lock()
+	p.plugin.emojiStampOptions = options
+	p.plugin.emojiStampExpiresAt = time.Now().Add(emojiStampCacheTTL)
+	p.plugin.emojiStampLock.Unlock()
+
+	return append([]*model.PostActionOptions{}, options...)
+}
+
+func (p *peerPostUsecase) fetchEmojiStampOptions() []*model.PostActionOptions {
+	options := []*model.PostActionOptions{}
+	for page := 0; page < emojiStampMaxPages; page++ {
+		emojis, err := p.plugin.API.GetEmojiList(model.EMOJI_SORT_BY_NAME, page, emojiStampPerPage)
+		if err != nil {
+			//カスタム絵文字が無効な場合など
+			p.plugin.API.LogWarn("Failed to GetEmojiList", "err", err.Error())
+			break
+		}
+		for _, emoji := range emojis {
+			//絵文字のスタンプを無効にしても集計に名前を表示できるよう、IDには名前を使う
+			options = append(options, &model.PostActionOptions{Text: emoji.Name, Value: emojiStampPrefix + emoji.Name})
+		}
+		if len(emojis) < emojiStampPerPage {
+			break
+		}
+	}
+	return options
+}
+
+func (p *peerPostUsecase) createBuiltinStampOptions() []*model.PostActionOptions {
 	return []*model.PostActionOptions{
 		{Text: "カッコいい", Value: "/stamp/stamp_1.png"},
 		{Text: "カワいい", Value: "/stamp/stamp_2.png"},
@@ -249,7 +765,63 @@ func (p *peerPostUsecase) createStampOptions() []*model.PostActionOptions {
 	}
 }
 
-func (p *peerPostUsecase) createHashtagOptions() []*model.PostActionOptions {
+func (p *peerPostUsecase) createHashtagOptions(teamID string) []*model.PostActionOptions {
+	config := p.plugin.getConfiguration()
+	return config.getHashtagOptions(teamID)
+}
+
+// createHashtagHelpText 選択できるハッシュタグの説明を並べる
+func (p *peerPostUsecase) createHashtagHelpText(teamID string) string {
 	config := p.plugin.getConfiguration()
-	return config.hashtagOptions
+	descriptions := []string{}
+	for _, option := range p.createHashtagOptions(teamID) {
+		hashtag := config.findHashtag(teamID, option.Value)
+		if hashtag == nil || hashtag.description == "" {
+			continue
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s: %s", hashtag.name, hashtag.description))
+	}
+	return strings.Join(descriptions, " / ")
+}
+
+func findOptionByValue(options []*model.PostActionOptions, value string) *model.PostActionOptions {
+	for _, option := range options {
+		if option.Value == value {
+			return option
+		}
+	}
+	return nil
+}
+
+func findOptionByText(options []*model.PostActionOptions, text string) *model.PostActionOptions {
+	for _, option := range options {
+		if option.Text == text {
+			return option
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hashtagElementName(index int) string {
+	return fmt.Sprintf("%s%d", dialogElementHashtag, index)
+}
+
+// joinHashtags 重複と空文字を除いてハッシュタグのPropの形式にする
+func joinHashtags(hashtags []string) string {
+	joined := []string{}
+	for _, tag := range hashtags {
+		if tag != "" && !containsString(joined, tag) {
+			joined = append(joined, tag)
+		}
+	}
+	return strings.Join(joined, " ")
 }
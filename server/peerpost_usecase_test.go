@@ -0,0 +1,183 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestParseInlineContent(t *testing.T) {
+	hashtagOptions := []*model.PostActionOptions{
+		{Text: "#迅速な対応", Value: "#迅速な対応"},
+		{Text: "#縁の下の力持ち", Value: "#縁の下の力持ち"},
+	}
+	stampOptions := []*model.PostActionOptions{
+		{Text: "GJ", Value: "/stamp/stamp_9.png"},
+		{Text: "いいね", Value: "/stamp/stamp_10.png"},
+	}
+
+	tests := []struct {
+		name     string
+		contents []string
+		want     *inlineContent
+		wantErr  bool
+	}{
+		{
+			name:     "メッセージ、ハッシュタグ、スタンプ",
+			contents: []string{"ありがとう！", "#迅速な対応", ":GJ:"},
+			want:     &inlineContent{text: "ありがとう！", hashtags: []string{"#迅速な対応"}, stamp: "/stamp/stamp_9.png"},
+		},
+		{
+			name:     "メッセージは空白で繋げる",
+			contents: []string{"本当に", "ありがとう", "#迅速な対応", "#縁の下の力持ち"},
+			want:     &inlineContent{text: "本当に ありがとう", hashtags: []string{"#迅速な対応", "#縁の下の力持ち"}},
+		},
+		{
+			name:     "重複したハッシュタグは一つにする",
+			contents: []string{"ありがとう", "#迅速な対応", "#迅速な対応"},
+			want:     &inlineContent{text: "ありがとう", hashtags: []string{"#迅速な対応"}},
+		},
+		{
+			name:     "コロンだけはメッセージとして扱う",
+			contents: []string{"ありがとう", "::", "#迅速な対応"},
+			want:     &inlineContent{text: "ありがとう ::", hashtags: []string{"#迅速な対応"}},
+		},
+		{
+			name:     "チームハッシュタグではない",
+			contents: []string{"ありがとう", "#存在しない"},
+			wantErr:  true,
+		},
+		{
+			name:     "該当するスタンプが無い場合はメッセージとして扱う",
+			contents: []string{"ありがとう", "#迅速な対応", ":smile:"},
+			want:     &inlineContent{text: "ありがとう :smile:", hashtags: []string{"#迅速な対応"}},
+		},
+		{
+			name:     "最後以外の絵文字はメッセージとして扱う",
+			contents: []string{"ありがとう", ":smile:", "#迅速な対応", ":GJ:"},
+			want:     &inlineContent{text: "ありがとう :smile:", hashtags: []string{"#迅速な対応"}, stamp: "/stamp/stamp_9.png"},
+		},
+		{
+			name:     "スタンプは最後の一つだけ",
+			contents: []string{"ありがとう", "#迅速な対応", ":GJ:", ":いいね:"},
+			want:     &inlineContent{text: "ありがとう :GJ:", hashtags: []string{"#迅速な対応"}, stamp: "/stamp/stamp_10.png"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseInlineContent(test.contents, hashtagOptions, stampOptions)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("parseInlineContent(%q) returned no error", test.contents)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseInlineContent(%q) returned error: %v", test.contents, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseInlineContent(%q) = %+v, want %+v", test.contents, got, test.want)
+			}
+		})
+	}
+}
+
+func TestConfigurationValidateSubmission(t *testing.T) {
+	configuration := &configuration{
+		minimumMessageLength: 5,
+		bannedWords:          []string{"バカ", "NG"},
+		minimumHashtags:      1,
+		maximumHashtags:      2,
+	}
+	hashtagOptions := []*model.PostActionOptions{
+		{Text: "#迅速な対応", Value: "#迅速な対応"},
+		{Text: "#縁の下の力持ち", Value: "#縁の下の力持ち"},
+	}
+
+	tests := []struct {
+		name       string
+		submission map[string]interface{}
+		want       map[string]string
+	}{
+		{
+			name: "妥当",
+			submission: map[string]interface{}{
+				dialogElementText:     "ありがとうございます",
+				hashtagElementName(1): "#迅速な対応",
+				hashtagElementName(2): "#縁の下の力持ち",
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "ハッシュタグの重複",
+			submission: map[string]interface{}{
+				dialogElementText:     "ありがとうございます",
+				hashtagElementName(1): "#迅速な対応",
+				hashtagElementName(2): "#迅速な対応",
+			},
+			want: map[string]string{hashtagElementName(2): "同じハッシュタグが選択されています。"},
+		},
+		{
+			name: "必須のハッシュタグが未選択",
+			submission: map[string]interface{}{
+				dialogElementText: "ありがとうございます",
+			},
+			want: map[string]string{hashtagElementName(1): "チームハッシュタグを選択してください。"},
+		},
+		{
+			name: "チームハッシュタグではない",
+			submission: map[string]interface{}{
+				dialogElementText:     "ありがとうございます",
+				hashtagElementName(1): "#存在しない",
+			},
+			want: map[string]string{hashtagElementName(1): "チームハッシュタグではありません。"},
+		},
+		{
+			name: "メッセージが空白のみ",
+			submission: map[string]interface{}{
+				dialogElementText:     "  \n ",
+				hashtagElementName(1): "#迅速な対応",
+			},
+			want: map[string]string{dialogElementText: "メッセージを入力してください。"},
+		},
+		{
+			name: "メッセージが最小文字数未満",
+			submission: map[string]interface{}{
+				dialogElementText:     "どうも",
+				hashtagElementName(1): "#迅速な対応",
+			},
+			want: map[string]string{dialogElementText: "メッセージは5文字以上で入力してください。"},
+		},
+		{
+			name: "禁止ワード（大文字小文字は区別しない）",
+			submission: map[string]interface{}{
+				dialogElementText:     "ありがとう、でもng",
+				hashtagElementName(1): "#迅速な対応",
+			},
+			want: map[string]string{dialogElementText: "使用できない言葉が含まれています。（NG）"},
+		},
+		{
+			name: "複数の項目のエラー",
+			submission: map[string]interface{}{
+				dialogElementText:     "バカ",
+				hashtagElementName(1): "#迅速な対応",
+				hashtagElementName(2): "#迅速な対応",
+			},
+			want: map[string]string{
+				dialogElementText:     "メッセージは5文字以上で入力してください。",
+				hashtagElementName(2): "同じハッシュタグが選択されています。",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := configuration.validateSubmission(test.submission, hashtagOptions)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("validateSubmission() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// personalReport ユーザ個人のピア投稿の一覧
+type personalReport struct {
+	userID         string
+	period         *reportPeriod
+	top            int
+	received       []*personalPost
+	sent           []*personalPost
+	displayNameMap map[string]string
+}
+
+// personalPost 個人のレポートの一覧に表示するピア投稿
+type personalPost struct {
+	id       string
+	createAt int64
+	fromID   string
+	toIDs    []string
+	text     string
+	hashtags []string
+	stamp    string
+}
+
+// executePersonal 期間中にユーザが受け取ったピア投稿と送ったピア投稿を表示する
+func (p *peerReportUsecase) executePersonal(args *model.CommandArgs, userID string, periodArgs []string, options *reportOptions) (*model.CommandResponse, *model.AppError) {
+	//個人のレポートは他のユーザに見せない
+	if options.post {
+		return p.plugin.createCommandResponse("個人のレポートは投稿できません。--dm を指定してください。"), nil
+	}
+
+	period, response, appErr := p.getPeriod(args, periodArgs)
+	if appErr != nil {
+		return nil, appErr
+	} else if response != nil {
+		return response, nil
+	}
+
+	report, appErr := p.collectPersonalPosts(args.TeamId, userID, period)
+	if appErr != nil {
+		p.plugin.API.LogError("Failed to collect personal posts", "err", appErr.Error())
+		return p.plugin.createCommandResponse("集計中にエラーが発生しました。"), nil
+	}
+	report.top = options.top
+
+	message, appErr := p.createPersonalReportMessage(args.TeamId, report)
+	if appErr != nil {
+		p.plugin.API.LogError("Failed to create personal report", "err", appErr.Error())
+		return p.plugin.createCommandResponse("集計中にエラーが発生しました。"), nil
+	}
+
+	return p.sendReport(args, message, options)
+}
+
+// executeColleague 指定したユーザのピア投稿を表示する（チーム管理者と設定で許可されたユーザのみ）
+func (p *peerReportUsecase) executeColleague(args *model.CommandArgs, mention string, periodArgs []string, options *reportOptions) (*model.CommandResponse, *model.AppError) {
+	configuration := p.plugin.getConfiguration()
+	if !p.plugin.API.HasPermissionToTeam(args.UserId, args.TeamId, model.PERMISSION_MANAGE_TEAM) &&
+		!containsString(configuration.reportViewerIDs, args.UserId) {
+		return p.plugin.createCommandResponse("他のユーザのレポートはチーム管理者と許可されたユーザのみ表示できます。"), nil
+	}
+
+	uc := peerPostUsecase{
+		plugin: p.plugin,
+	}
+	user, response, appErr := uc.findMentionedUser(mention, commandPeerReportUsage)
+	if appErr != nil {
+		return nil, appErr
+	} else if response != nil {
+		return response, nil
+	}
+
+	return p.executePersonal(args, user.Id, periodArgs, options)
+}
+
+// collectPersonalPosts ピア投稿部屋の投稿からユーザが受け取ったピア投稿と送ったピア投稿を集める
+// 一覧にはメッセージが必要なため、日毎の集計ではなく投稿を新しい方から集計期間の開始まで読む
+func (p *peerReportUsecase) collectPersonalPosts(teamID string, userID string, period *reportPeriod) (*personalReport, *model.AppError) {
+	configuration := p.plugin.getConfiguration()
+	channelID := configuration.channelIds[teamID]
+
+	posts := []*personalPost{}
+	for page := 0; ; page++ {
+		postList, appErr := p.plugin.API.GetPostsForChannel(channelID, page, postIndexPostsPerPage)
+		if appErr != nil {
+			return nil, appErr
+		}
+		for _, postID := range postList.Order {
+			if post := p.createPersonalPost(postList.Posts[postID], userID, period); post != nil {
+				posts = append(posts, post)
+			}
+		}
+		if len(postList.Order) < postIndexPostsPerPage {
+			break //最後のページ
+		}
+		if oldest := postList.Posts[postList.Order[len(postList.Order)-1]]; oldest.CreateAt < period.from.Unix()*1000 {
+			break //集計期間より前まで読んだ
+		}
+	}
+
+	//投稿の古い順に並べる
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].createAt < posts[j].createAt
+	})
+
+	report := &personalReport{
+		userID:   userID,
+		period:   period,
+		received: []*personalPost{},
+		sent:     []*personalPost{},
+	}
+	userIDs := []string{userID}
+	for _, post := range posts {
+		if containsString(post.toIDs, userID) {
+			report.received = append(report.received, post)
+			userIDs = append(userIDs, post.fromID)
+		}
+		if post.fromID == userID {
+			report.sent = append(report.sent, post)
+			userIDs = append(userIDs, post.toIDs...)
+		}
+	}
+
+	displayNames, appErr := p.plugin.getDisplayNames(teamID, userIDs)
+	if appErr != nil {
+		return nil, appErr
+	}
+	report.displayNameMap = displayNames
+
+	return report, nil
+}
+
+// createPersonalPost 集計期間に投稿された、ユーザが送ったか受け取ったピア投稿を一覧の形にする（それ以外はnil）
+func (p *peerReportUsecase) createPersonalPost(post *model.Post, userID string, period *reportPeriod) *personalPost {
+	if post.Type != "custom_peer-post" || post.DeleteAt != 0 || !period.contains(post.CreateAt) {
+		return nil
+	}
+	fromTo, ok := post.Props["from-to"].(string)
+	if !ok {
+		return nil //本来あり得ない
+	}
+	ids := strings.Fields(fromTo)
+	if len(ids) < 2 || (ids[0] != userID && !containsString(ids[1:], userID)) {
+		return nil
+	}
+
+	//先頭がfrom、以降は全てto
+	return &personalPost{
+		id:       post.Id,
+		createAt: post.CreateAt,
+		fromID:   ids[0],
+		toIDs:    ids[1:],
+		text:     getPeerPostText(post),
+		hashtags: strings.Fields(post.Hashtags),
+		stamp:    p.plugin.getPostStamp(post),
+	}
+}
+
+func (p *peerReportUsecase) createPersonalReportMessage(teamID string, report *personalReport) (string, *model.AppError) {
+	uc := peerPostUsecase{
+		plugin: p.plugin,
+	}
+	stampOptions := uc.createAllStampOptions()
+
+	//リンクに使うチームと設定は一度だけ取得する
+	permalinkPrefix, err := p.plugin.getPermanentLinkURLPrefix(teamID)
+	if err != nil {
+		return "", model.NewAppError("createPersonalReportMessage", "peerpost.get_permalink.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString(fmt.Sprintf("%sさんのピア投稿\n\n", report.displayNameMap[report.userID]))
+	buf.WriteString(fmt.Sprintf("集計期間：%s\n\n", report.period.describe()))
+
+	received := limitPosts(report.received, report.top)
+	buf.WriteString(fmt.Sprintf("受け取ったピア投稿（%s）\n\n", describePostCount(len(report.received), len(received))))
+	for _, post := range received {
+		buf.WriteString(p.formatPersonalPost(permalinkPrefix, report, stampOptions, post, report.displayNameMap[post.fromID]+"さんから"))
+	}
+
+	buf.WriteString("\n\n")
+
+	sent := limitPosts(report.sent, report.top)
+	buf.WriteString(fmt.Sprintf("送ったピア投稿（%s）\n\n", describePostCount(len(report.sent), len(sent))))
+	for _, post := range sent {
+		names := []string{}
+		for _, toID := range post.toIDs {
+			names = append(names, report.displayNameMap[toID]+"さん")
+		}
+		buf.WriteString(p.formatPersonalPost(permalinkPrefix, report, stampOptions, post, strings.Join(names, "、")+"へ"))
+	}
+
+	buf.WriteString("\n\n")
+
+	if err := p.writePersonalSummary(&buf, teamID, report); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// limitPosts 一覧を新しい方から指定数までにする（0の場合は全て）
+// postsは古い順に並んでいること
+func limitPosts(posts []*personalPost, top int) []*personalPost {
+	if top > 0 && len(posts) > top {
+		return posts[len(posts)-top:]
+	}
+	return posts
+}
+
+// describePostCount 一覧の件数を表示用に整形する
+func describePostCount(total int, shown int) string {
+	if shown < total {
+		return fmt.Sprintf("%d件、新しい%d件を表示", total, shown)
+	}
+	return fmt.Sprintf("%d件", total)
+}
+
+// writePersonalSummary 受け取ったピア投稿のハッシュタグと、よく褒めてくれた人を書き出す
+// 受け取ったピア投稿だけをランキングと同じ方法で数える
+func (p *peerReportUsecase) writePersonalSummary(buf *bytes.Buffer, teamID string, report *personalReport) *model.AppError {
+	counts := newPostCounts()
+	for _, post := range report.received {
+		fromTo := strings.Join(append([]string{post.fromID}, post.toIDs...), " ")
+		counts.add(fromTo, strings.Join(post.hashtags, " "), post.stamp, nil)
+	}
+	rank, err := p.createRanking(teamID, report.period, counts)
+	if err != nil {
+		return model.NewAppError("writePersonalSummary", "peerpost.count_post.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	rank.top = report.top
+
+	buf.WriteString("受け取ったハッシュタグ\n\n")
+	buf.WriteString("| ハッシュタグ | 回数 | スコア |\n")
+	buf.WriteString("| :--- | ---: | ---: |\n")
+	for _, pair := range limitRanking(rank.hashTagRanking, rank.top) {
+		text := fmt.Sprintf("|%s|%d|%s|\n", pair.key, pair.count, formatScore(rank.hashTagScoreMap[pair.key]))
+		buf.WriteString(text)
+	}
+
+	buf.WriteString("\n\n")
+
+	buf.WriteString("よく褒めてくれた人\n\n")
+	buf.WriteString("| 名前 | 回数 |\n")
+	buf.WriteString("| :--- | ---: |\n")
+	for _, pair := range limitRanking(rank.fromRanking, rank.top) {
+		text := fmt.Sprintf("|%s|%d|\n", rank.displayNameMap[pair.key], pair.count)
+		buf.WriteString(text)
+	}
+	return nil
+}
+
+// formatPersonalPost ピア投稿を一覧の１行に整形する（日時、相手、メッセージ、ハッシュタグ、スタンプ、リンク）
+func (p *peerReportUsecase) formatPersonalPost(permalinkPrefix string, report *personalReport, stampOptions []*model.PostActionOptions, post *personalPost, partner string) string {
+	createAt := time.Unix(0, post.createAt*int64(time.Millisecond)).In(report.period.from.Location())
+	line := fmt.Sprintf("- %s %s：%s", createAt.Format("2006/01/02 15:04"), partner, post.text)
+	if len(post.hashtags) > 0 {
+		line += " " + strings.Join(post.hashtags, " ")
+	}
+	if post.stamp != "" {
+		line += fmt.Sprintf("（スタンプ：%s）", p.getStampLabel(stampOptions, post.stamp))
+	}
+	line += fmt.Sprintf(" [投稿を見る](%s%s)\n", permalinkPrefix, post.id)
+	return line
+}
+
+// getPeerPostText ピア投稿の本文からメッセージを取り出す
+// 本文は「宛先」「メッセージ」「ハッシュタグ」の行からなる
+func getPeerPostText(post *model.Post) string {
+	for _, attachment := range post.Attachments() {
+		lines := strings.Split(attachment.Text, "\n")
+		if len(lines) < 3 {
+			return attachment.Text
+		}
+		return strings.Join(lines[1:len(lines)-1], " ")
+	}
+	return ""
+}
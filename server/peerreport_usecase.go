@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,15 +18,22 @@ type peerReportUsecase struct {
 }
 
 const (
-	commandPeerReportUsage = "** Slash Command Help **\n\n  /peer-report [YYYY/MM/DD]\n\n  - 日付は省略可能です。\n\n  - 日付を省略した場合は今週の月曜日からの集計となります。\n\n  - 集計期間は指定した日から現在まで。"
+	commandPeerReportUsage = "** Slash Command Help **\n\n  /peer-report [YYYY/MM/DD [YYYY/MM/DD]]\n\n  /peer-report [today | this-week | last-week | this-month | last-month | quarter | fiscal-year]\n\n  - 期間は省略可能です。\n\n  - 期間を省略した場合は今週（設定の週の開始曜日から）の集計となります。今週まだ営業日が無い場合は先週の集計となります。\n\n  - 日付を１つ指定した場合は指定した日から現在まで、２つ指定した場合は開始日から終了日までの集計となります。\n\n  - quarter, fiscal-year は設定の年度の開始月に従った今の四半期、今年度の集計となります。\n\n  /peer-report me [期間]\n\n  - 期間中に自分が受け取ったピア投稿と、送ったピア投稿を一覧で表示します。\n\n  /peer-report @ユーザ名 [期間]\n\n  - 指定したユーザが受け取ったピア投稿と、送ったピア投稿を一覧で表示します。（チーム管理者と設定で許可されたユーザのみ）\n\n  - 以下のオプションを指定できます。\n\n    --post ピア投稿部屋にBotとして投稿します。（/peer-report [期間] のみ）\n\n    --dm Botからのダイレクトメッセージで送ります。\n\n    --top N 各表を上位N件、ピア投稿の一覧を新しいN件までにします。\n\n  /peer-report rebuild\n\n  - 日毎の集計を全ての投稿から作り直します。（システム管理者のみ）"
 )
 
 type ranking struct {
+	teamID          string
+	period          *reportPeriod
+	top             int
 	fromRanking     []userIDCountPair
 	toRanking       []userIDCountPair
 	reactionRanking []userIDCountPair
 	hashTagRanking  []userIDCountPair
+	stampRanking    []userIDCountPair
 	displayNameMap  map[string]string
+	toScoreMap      map[string]float64
+	hashTagScoreMap map[string]float64
+	toStampMap      map[string]map[string]int
 }
 
 type userIDCountPair struct {
@@ -32,148 +41,231 @@ type userIDCountPair struct {
 	count int
 }
 
-func (p *peerReportUsecase) execute(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+// reportOptions レポートの送り方と表の長さの指定
+type reportOptions struct {
+	post bool
+	dm   bool
+	top  int
+}
 
-	fields := strings.Fields(args.Command)
-	if len(fields) > 2 {
-		return p.plugin.createErrorCommandResponse(commandPeerReportUsage), nil
+// parseReportOptions コマンドの引数からオプションを取り出す（オプション以外の引数はそのまま返す）
+func parseReportOptions(fields []string) ([]string, *reportOptions, error) {
+	rest := []string{}
+	options := &reportOptions{}
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "--post":
+			options.post = true
+		case "--dm":
+			options.dm = true
+		case "--top":
+			if i+1 >= len(fields) {
+				return nil, nil, errors.New("--top には件数を指定してください。\n\n" + commandPeerReportUsage)
+			}
+			top, err := strconv.Atoi(fields[i+1])
+			if err != nil || top < 1 {
+				return nil, nil, errors.New("--top の件数は1以上の数値で指定してください。\n\n" + commandPeerReportUsage)
+			}
+			options.top = top
+			i++
+		default:
+			rest = append(rest, fields[i])
+		}
 	}
+	return rest, options, nil
+}
 
-	var arg string = ""
-	if len(fields) == 2 {
-		arg = fields[1]
-	}
+func (p *peerReportUsecase) execute(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 
-	from, err := p.getFromDate(arg)
+	fields, options, err := parseReportOptions(strings.Fields(args.Command))
 	if err != nil {
-		return p.plugin.createErrorCommandResponse(err.Error()), nil
+		return p.plugin.createCommandResponse(err.Error()), nil
+	}
+	if len(fields) == 2 && fields[1] == "rebuild" {
+		return p.executeRebuild(args)
+	}
+	if len(fields) >= 2 && fields[1] == "me" {
+		return p.executePersonal(args, args.UserId, fields[2:], options)
+	}
+	if len(fields) >= 2 && strings.HasPrefix(fields[1], "@") {
+		return p.executeColleague(args, fields[1], fields[2:], options)
+	}
+	if len(fields) > 3 {
+		return p.plugin.createCommandResponse(commandPeerReportUsage), nil
+	}
+
+	period, response, appErr := p.getPeriod(args, fields[1:])
+	if appErr != nil {
+		return nil, appErr
+	} else if response != nil {
+		return response, nil
 	}
 
 	configuration := p.plugin.getConfiguration()
 	channelID := configuration.channelIds[args.TeamId]
 
 	//指定のチャンネルに投稿されたPostから各種数値を数える
-	info, err := p.countPost(channelID, from)
+	info, err := p.countPost(args.TeamId, channelID, period)
+	if err != nil {
+		p.plugin.API.LogError("Failed to count posts", "err", err.Error())
+		return p.plugin.createCommandResponse("集計中にエラーが発生しました。"), nil
+	}
+	info.top = options.top
 
 	message := p.createReportMessage(info)
 
-	configurtion := p.plugin.getConfiguration()
-	post := model.Post{
-		ChannelId: args.ChannelId,
-		UserId:    configurtion.bot.UserId,
-		Message:   message,
+	return p.sendReport(args, message, options)
+}
+
+// getPeriod コマンドの引数から集計期間を求める
+// 集計期間の区切りはコマンドを実行したユーザのタイムゾーンで求める
+func (p *peerReportUsecase) getPeriod(args *model.CommandArgs, periodArgs []string) (*reportPeriod, *model.CommandResponse, *model.AppError) {
+	if len(periodArgs) > 2 {
+		return nil, p.plugin.createCommandResponse(commandPeerReportUsage), nil
 	}
 
-	if postResult := p.plugin.API.SendEphemeralPost(args.UserId, &post); postResult == nil {
-		p.plugin.API.LogError("faild to SendEphemeralPost", "err", post)
-		return nil, nil
+	user, appErr := p.plugin.getUserByID(args.UserId)
+	if appErr != nil {
+		return nil, nil, appErr
 	}
+	now := time.Now().In(p.plugin.getUserLocation(*user))
 
-	return &model.CommandResponse{}, nil
-}
-func (p *peerReportUsecase) addCount(countMap *map[string]int, id string) {
-	if count, ok := (*countMap)[id]; ok {
-		(*countMap)[id] = count + 1
-	} else {
-		(*countMap)[id] = 1
-	}
-}
-
-func (p *peerReportUsecase) getFromDate(arg string) (time.Time, error) {
-	var from time.Time
-	var err error = nil
-	if arg == "" {
-		today, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
-		dayOfWeek := int(today.Weekday()+6) % 7  //0:月曜
-		from = today.AddDate(0, 0, -1*dayOfWeek) //今週の月曜日を求める
-	} else {
-		from, err = time.Parse("2006/01/02", arg)
-		if err != nil {
-			err = errors.New("有効な日付ではありません。\n\n" + commandPeerReportUsage)
-		}
+	configuration := p.plugin.getConfiguration()
+	period, err := configuration.getReportPeriod(args.TeamId, periodArgs, now)
+	if err != nil {
+		return nil, p.plugin.createCommandResponse(err.Error()), nil
 	}
-	return from, err
+	return period, nil, nil
 }
 
-func (p *peerReportUsecase) countPost(channelID string, from time.Time) (*ranking, error) {
-	var fromMilliSecond int64 = from.Unix() * 1000
-	postList, appError := p.plugin.API.GetPostsSince(channelID, fromMilliSecond)
-	if appError != nil {
-		return nil, appError
-	}
+// sendReport レポートを送る
+// オプションの指定が無い場合はコマンドを実行したユーザにのみ表示する
+// １つの投稿に収まらない長さのレポートは複数の投稿に分けて送る
+func (p *peerReportUsecase) sendReport(args *model.CommandArgs, message string, options *reportOptions) (*model.CommandResponse, *model.AppError) {
+	configuration := p.plugin.getConfiguration()
+	messages := splitReportMessage(message, reportMessageMaxRunes)
 
-	fromCountMap := map[string]int{}
-	toCountMap := map[string]int{}
-	reactionCountMap := map[string]int{}
-	hashTagCountMap := map[string]int{}
-	rank := ranking{
-		fromRanking:     []userIDCountPair{},
-		toRanking:       []userIDCountPair{},
-		reactionRanking: []userIDCountPair{},
-		hashTagRanking:  []userIDCountPair{},
-		displayNameMap:  map[string]string{},
+	if options.post || options.dm {
+		results := []string{}
+		if options.post {
+			//ピア投稿部屋にBotとして投稿
+			if err := p.createReportPosts(configuration.channelIds[args.TeamId], messages); err != nil {
+				return nil, err
+			}
+			results = append(results, "ピア投稿部屋に投稿しました。")
+		}
+		if options.dm {
+			//Botからコマンドを実行したユーザへのダイレクトメッセージ
+			channel, err := p.plugin.API.GetDirectChannel(args.UserId, configuration.bot.UserId)
+			if err != nil {
+				p.plugin.API.LogError("Failed to GetDirectChannel", "err", err.Error())
+				return nil, err
+			}
+			if err := p.createReportPosts(channel.Id, messages); err != nil {
+				return nil, err
+			}
+			results = append(results, "ダイレクトメッセージを送りました。")
+		}
+		return p.plugin.createCommandResponse("レポートを" + strings.Join(results, "また、")), nil
 	}
 
-	for _, post := range postList.Posts {
-		if post.Type != "custom_peer-post" {
-			continue //違う投稿
+	for _, message := range messages {
+		post := model.Post{
+			ChannelId: args.ChannelId,
+			UserId:    configuration.bot.UserId,
+			Message:   message,
 		}
-		if post.DeleteAt != 0 {
-			continue //削除済み
+
+		if postResult := p.plugin.API.SendEphemeralPost(args.UserId, &post); postResult == nil {
+			p.plugin.API.LogError("faild to SendEphemeralPost", "err", post)
+			return nil, nil
 		}
-		value, ok := post.Props["from-to"]
-		if !ok {
-			continue //本来あり得ない
+	}
+
+	return &model.CommandResponse{}, nil
+}
+
+// createReportPosts 分けたレポートを順番にBotとして投稿する
+func (p *peerReportUsecase) createReportPosts(channelID string, messages []string) *model.AppError {
+	configuration := p.plugin.getConfiguration()
+	for _, message := range messages {
+		if _, err := p.plugin.API.CreatePost(&model.Post{
+			ChannelId: channelID,
+			UserId:    configuration.bot.UserId,
+			Message:   message,
+		}); err != nil {
+			p.plugin.API.LogError("Failed to CreatePost", "err", err.Error())
+			return err
 		}
-		fromTo, ok := value.(string)
-		if !ok {
-			p.plugin.API.LogError("Failed to type assertion", "err", post.Props["peer-post"])
-			panic(value)
+	}
+	return nil
+}
+
+// executeRebuild 全てのチームのピア投稿部屋の日毎の集計を作り直す
+func (p *peerReportUsecase) executeRebuild(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	if !p.plugin.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return p.plugin.createCommandResponse("このコマンドはシステム管理者のみ実行できます。"), nil
+	}
+
+	configuration := p.plugin.getConfiguration()
+	for _, channelID := range configuration.channelIds {
+		if err := p.plugin.rebuildPostIndex(channelID); err != nil {
+			p.plugin.API.LogError("Failed to rebuild post index", "err", err.Error())
+			return p.plugin.createCommandResponse("集計の作り直し中にエラーが発生しました。"), nil
 		}
+	}
 
-		//from,toで登場した数を数える
-		ids := strings.Split(fromTo, " ")
-		p.addCount(&fromCountMap, ids[0]) //fromとして登場した数
-		p.addCount(&toCountMap, ids[1])   //toとして登場した数
+	return p.plugin.createCommandResponse("ピア投稿の集計を作り直しました。"), nil
+}
 
-		//登場したUserIDをmapで集める
-		rank.displayNameMap[ids[0]] = "" //値は後で解決
-		rank.displayNameMap[ids[1]] = "" //値は後で解決
+func (p *peerReportUsecase) countPost(teamID string, channelID string, period *reportPeriod) (*ranking, error) {
+	//前回から更新された投稿を反映してから、日毎の集計で期間内のピア投稿を数える
+	if appError := p.plugin.preparePostIndex(channelID); appError != nil {
+		return nil, appError
+	}
+	counts, appError := p.plugin.getPostCounts(channelID, period)
+	if appError != nil {
+		return nil, appError
+	}
 
-		//ハッシュタグの登場回数を数える
-		if post.Hashtags != "" {
-			for _, tag := range strings.Split(post.Hashtags, " ") {
-				p.addCount(&hashTagCountMap, tag)
-			}
-		}
+	return p.createRanking(teamID, period, counts)
+}
 
-		if post.HasReactions {
-			//リアクションの数をユーザ毎に数える
-			var reactions []*model.Reaction
-			reactions, err := p.plugin.API.GetReactions(post.Id)
-			if err != nil {
-				return nil, err
-			}
-			for _, reaction := range reactions {
-				userID := reaction.UserId
-				rank.displayNameMap[userID] = ""      //値は後で解決
-				p.addCount(&reactionCountMap, userID) //リアクションの数を数える
-			}
-		}
+// createRanking 数えた結果からランキングを作る
+func (p *peerReportUsecase) createRanking(teamID string, period *reportPeriod, counts *postCounts) (*ranking, error) {
+	//別名は正式なハッシュタグとして数え、重みからスコアを求める
+	configuration := p.plugin.getConfiguration()
+	counts.mergeHashtags(configuration.getCanonicalHashtag)
+	toScoreMap, hashTagScoreMap := counts.scores(func(tag string) float64 {
+		return configuration.getHashtagWeight(teamID, tag)
+	})
+
+	rank := ranking{
+		teamID:          teamID,
+		period:          period,
+		fromRanking:     p.sortCountMap(&counts.From),
+		toRanking:       p.sortCountMap(&counts.To),
+		reactionRanking: p.sortCountMap(&counts.Reactors),
+		hashTagRanking:  p.sortCountMap(&counts.Hashtags),
+		stampRanking:    p.sortCountMap(&counts.Stamps),
+		displayNameMap:  map[string]string{},
+		toScoreMap:      toScoreMap,
+		hashTagScoreMap: hashTagScoreMap,
+		toStampMap:      counts.ToStamps,
 	}
 
-	//ユーザIDのmapからディスプレイ名を取得する
-	for userID := range rank.displayNameMap {
-		user, err := p.plugin.API.GetUser(userID)
-		if err != nil {
-			return nil, err
+	//登場したユーザIDからディスプレイ名をまとめて取得する
+	userIDs := []string{}
+	for _, countMap := range []map[string]int{counts.From, counts.To, counts.Reactors} {
+		for userID := range countMap {
+			userIDs = append(userIDs, userID)
 		}
-		rank.displayNameMap[userID] = p.plugin.getUserDisplayName(*user)
 	}
-	rank.fromRanking = p.sortCountMap(&fromCountMap)
-	rank.toRanking = p.sortCountMap(&toCountMap)
-	rank.reactionRanking = p.sortCountMap(&reactionCountMap)
-	rank.hashTagRanking = p.sortCountMap(&hashTagCountMap)
+	displayNames, appError := p.plugin.getDisplayNames(teamID, userIDs)
+	if appError != nil {
+		return nil, appError
+	}
+	rank.displayNameMap = displayNames
 
 	return &rank, nil
 }
@@ -182,11 +274,13 @@ func (p *peerReportUsecase) createReportMessage(rank *ranking) string {
 
 	var buf bytes.Buffer
 
+	buf.WriteString(fmt.Sprintf("集計期間：%s\n\n", rank.period.describe()))
+
 	buf.WriteString("褒められた回数\n\n")
-	buf.WriteString("| 名前 | 回数 |\n")
-	buf.WriteString("| :--- | ---: |\n")
-	for _, pair := range rank.toRanking {
-		text := fmt.Sprintf("|%s|%d|\n", rank.displayNameMap[pair.key], pair.count)
+	buf.WriteString("| 名前 | 回数 | スコア |\n")
+	buf.WriteString("| :--- | ---: | ---: |\n")
+	for _, pair := range limitRanking(rank.toRanking, rank.top) {
+		text := fmt.Sprintf("|%s|%d|%s|\n", rank.displayNameMap[pair.key], pair.count, formatScore(rank.toScoreMap[pair.key]))
 		buf.WriteString(text)
 	}
 
@@ -195,7 +289,7 @@ func (p *peerReportUsecase) createReportMessage(rank *ranking) string {
 	buf.WriteString("褒めた回数\n\n")
 	buf.WriteString("| 名前 | 回数 |\n")
 	buf.WriteString("| :--- | ---: |\n")
-	for _, pair := range rank.fromRanking {
+	for _, pair := range limitRanking(rank.fromRanking, rank.top) {
 		text := fmt.Sprintf("|%s|%d|\n", rank.displayNameMap[pair.key], pair.count)
 		buf.WriteString(text)
 	}
@@ -205,21 +299,31 @@ func (p *peerReportUsecase) createReportMessage(rank *ranking) string {
 	buf.WriteString("リアクション回数\n\n")
 	buf.WriteString("| 名前 | 回数 |\n")
 	buf.WriteString("| :--- | ---: |\n")
-	for _, pair := range rank.reactionRanking {
+	for _, pair := range limitRanking(rank.reactionRanking, rank.top) {
 		text := fmt.Sprintf("|%s|%d|\n", rank.displayNameMap[pair.key], pair.count)
 		buf.WriteString(text)
 	}
 
 	buf.WriteString("\n\n")
 
+	configuration := p.plugin.getConfiguration()
 	buf.WriteString("ハッシュタグ使用回数\n\n")
-	buf.WriteString("| ハッシュタグ | 回数 |\n")
-	buf.WriteString("| :--- | ---: |\n")
-	for _, pair := range rank.hashTagRanking {
-		text := fmt.Sprintf("|%s|%d|\n", pair.key, pair.count)
+	buf.WriteString("| ハッシュタグ | 回数 | スコア |\n")
+	buf.WriteString("| :--- | ---: | ---: |\n")
+	for _, pair := range limitRanking(rank.hashTagRanking, rank.top) {
+		//チームの現在のハッシュタグに無いもの、廃止済みのものは旧ハッシュタグとして表示
+		name := pair.key
+		if hashtag := configuration.findHashtag(rank.teamID, pair.key); hashtag == nil || hashtag.retired {
+			name += "（旧）"
+		}
+		text := fmt.Sprintf("|%s|%d|%s|\n", name, pair.count, formatScore(rank.hashTagScoreMap[pair.key]))
 		buf.WriteString(text)
 	}
 
+	buf.WriteString("\n\n")
+
+	p.writeStampReport(&buf, rank)
+
 	message := buf.String()
 	return message
 }
@@ -246,3 +350,71 @@ func (p *peerReportUsecase) sortCountMap(countMap *map[string]int) []userIDCount
 	})
 	return sorter
 }
+
+// limitRanking ランキングを上位の指定数までにする（0の場合は全て）
+func limitRanking(pairs []userIDCountPair, top int) []userIDCountPair {
+	if top > 0 && len(pairs) > top {
+		return pairs[:top]
+	}
+	return pairs
+}
+
+// formatScore 重み付きスコアを表示用に整形する（小数点以下２桁まで）
+func formatScore(score float64) string {
+	return strconv.FormatFloat(math.Round(score*100)/100, 'f', -1, 64)
+}
+
+// writeStampReport スタンプの使用回数と、宛先毎によく贈られたスタンプを書き出す
+func (p *peerReportUsecase) writeStampReport(buf *bytes.Buffer, rank *ranking) {
+	uc := peerPostUsecase{
+		plugin: p.plugin,
+	}
+	allOptions := uc.createAllStampOptions()
+
+	//使われなかったスタンプも見直しのため回数0で表示する（カスタム絵文字は数が多いため除く）
+	stampRanking := rank.stampRanking
+	used := map[string]bool{}
+	for _, pair := range stampRanking {
+		used[pair.key] = true
+	}
+	for _, option := range uc.createStampOptions(rank.teamID) {
+		if !used[option.Value] && !strings.HasPrefix(option.Value, emojiStampPrefix) {
+			stampRanking = append(stampRanking, userIDCountPair{key: option.Value, count: 0})
+		}
+	}
+
+	buf.WriteString("スタンプ使用回数\n\n")
+	buf.WriteString("| スタンプ | 回数 |\n")
+	buf.WriteString("| :--- | ---: |\n")
+	for _, pair := range limitRanking(stampRanking, rank.top) {
+		text := fmt.Sprintf("|%s|%d|\n", p.getStampLabel(allOptions, pair.key), pair.count)
+		buf.WriteString(text)
+	}
+
+	buf.WriteString("\n\n")
+
+	buf.WriteString("よく贈られたスタンプ\n\n")
+	buf.WriteString("| 名前 | スタンプ | 回数 |\n")
+	buf.WriteString("| :--- | :--- | ---: |\n")
+	for _, pair := range limitRanking(rank.toRanking, rank.top) {
+		stampMap, ok := rank.toStampMap[pair.key]
+		if !ok {
+			continue //スタンプ無しの投稿のみ
+		}
+		top := p.sortCountMap(&stampMap)[0]
+		text := fmt.Sprintf("|%s|%s|%d|\n", rank.displayNameMap[pair.key], p.getStampLabel(allOptions, top.key), top.count)
+		buf.WriteString(text)
+	}
+}
+
+// getStampLabel スタンプのIDから表示用のラベルを求める
+// カスタム絵文字はIDが名前のため、絵文字のスタンプを無効にした後もそのまま表示する
+func (p *peerReportUsecase) getStampLabel(options []*model.PostActionOptions, stamp string) string {
+	if strings.HasPrefix(stamp, emojiStampPrefix) {
+		return strings.TrimPrefix(stamp, emojiStampPrefix)
+	}
+	if option := findOptionByValue(options, stamp); option != nil {
+		return option.Text
+	}
+	return "（削除済み）"
+}
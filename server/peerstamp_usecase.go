@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+type peerStampUsecase struct {
+	plugin *Plugin
+}
+
+const (
+	commandPeerStampUsage = "** ピア投稿スタンプ管理 Slash Command Help **\n\n  /peer-stamp add ラベル\n\n  /peer-stamp remove ラベル\n\n  /peer-stamp list\n\n  - add はこのチャンネルに直前に投稿した画像（PNG、GIF、JPEG）をスタンプとして追加します。先に画像を投稿してから実行してください。\n\n  - ラベルには空白を含められます。（空白を含むラベルはインライン投稿では指定できません）\n\n  - remove したスタンプはダイアログに表示されなくなります。（過去の投稿の表示のため画像は残ります）\n\n  - add, remove はシステム管理者のみ実行できます。"
+
+	stampSearchPostsPerPage = 30
+	stampImageMaxSize       = 1024 * 1024
+)
+
+// stampImageFormats スタンプとして受け付ける画像の形式（image.DecodeConfigの形式名）
+var stampImageFormats = []string{"png", "gif", "jpeg"}
+
+func (p *peerStampUsecase) execute(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return p.plugin.createCommandResponse(commandPeerStampUsage), nil
+	}
+
+	switch fields[1] {
+	case "list":
+		if len(fields) != 2 {
+			return p.plugin.createCommandResponse(commandPeerStampUsage), nil
+		}
+		return p.executeList()
+	case "add", "remove":
+		if len(fields) < 3 {
+			return p.plugin.createCommandResponse(commandPeerStampUsage), nil
+		}
+		if !p.plugin.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+			return p.plugin.createCommandResponse("このコマンドはシステム管理者のみ実行できます。"), nil
+		}
+		//ラベルは空白を含めて残り全て
+		label := strings.Join(fields[2:], " ")
+		if fields[1] == "add" {
+			return p.executeAdd(args, label)
+		}
+		return p.executeRemove(label)
+	default:
+		return p.plugin.createCommandResponse(commandPeerStampUsage), nil
+	}
+}
+
+func (p *peerStampUsecase) executeList() (*model.CommandResponse, *model.AppError) {
+	stamps, err := p.plugin.getCustomStamps()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("追加されたスタンプ\n\n")
+	buf.WriteString("| ラベル | 画像 |\n")
+	buf.WriteString("| :--- | :--- |\n")
+	for _, stamp := range stamps {
+		text := fmt.Sprintf("|%s|![%s](%s)|\n", stamp.Label, stamp.Label, p.plugin.getServerHTTPURL(stamp.path()))
+		buf.WriteString(text)
+	}
+
+	buf.WriteString("\n\n")
+
+	buf.WriteString("スタンプパック\n\n")
+	buf.WriteString("| パック | スタンプ |\n")
+	buf.WriteString("| :--- | :--- |\n")
+	for _, pack := range p.plugin.getConfiguration().stampPacks {
+		text := fmt.Sprintf("|%s|%s|\n", pack.describe(), strings.Join(pack.labels, ", "))
+		buf.WriteString(text)
+	}
+
+	return p.plugin.createCommandResponse(buf.String()), nil
+}
+
+func (p *peerStampUsecase) executeAdd(args *model.CommandArgs, label string) (*model.CommandResponse, *model.AppError) {
+	//組み込みのスタンプを含めてラベルが重複しないこと（インライン投稿でラベルを使うため）
+	uc := peerPostUsecase{
+		plugin: p.plugin,
+	}
+	if findOptionByText(uc.createAllStampOptions(), label) != nil {
+		return p.plugin.createCommandResponse(fmt.Sprintf("同じラベルのスタンプが既にあります。（%s）", label)), nil
+	}
+
+	//直前に投稿された画像を探す
+	fileInfo, err := p.findLatestImage(args.ChannelId, args.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo == nil {
+		return p.plugin.createCommandResponse("スタンプにする画像が見つかりませんでした。このチャンネルに画像を投稿してから実行してください。"), nil
+	}
+	if fileInfo.Size > stampImageMaxSize {
+		return p.plugin.createCommandResponse(fmt.Sprintf("画像のサイズは%dKBまでです。", stampImageMaxSize/1024)), nil
+	}
+	data, err := p.plugin.API.GetFile(fileInfo.Id)
+	if err != nil {
+		return nil, err
+	}
+	//ファイル名や申告されたMIMEタイプではなく中身で確認する（SVGなどスクリプトを含められる形式は受け付けない）
+	if _, format, decodeErr := image.DecodeConfig(bytes.NewReader(data)); decodeErr != nil || !containsString(stampImageFormats, format) {
+		return p.plugin.createCommandResponse("スタンプにできる画像はPNG、GIF、JPEGのみです。"), nil
+	}
+
+	stamp := &customStamp{
+		ID:    model.NewId(),
+		Label: label,
+	}
+	if err := p.plugin.saveCustomStampImage(stamp.ID, data); err != nil {
+		return nil, err
+	}
+	stamps, err := p.plugin.getCustomStamps()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.plugin.saveCustomStamps(append(stamps, stamp)); err != nil {
+		return nil, err
+	}
+
+	return p.plugin.createCommandResponse(fmt.Sprintf("スタンプを追加しました。（%s）", label)), nil
+}
+
+func (p *peerStampUsecase) executeRemove(label string) (*model.CommandResponse, *model.AppError) {
+	stamps, err := p.plugin.getCustomStamps()
+	if err != nil {
+		return nil, err
+	}
+
+	remains := []*customStamp{}
+	for _, stamp := range stamps {
+		if stamp.Label != label {
+			remains = append(remains, stamp)
+		}
+	}
+	if len(remains) == len(stamps) {
+		return p.plugin.createCommandResponse(fmt.Sprintf("該当するスタンプを見つけることができませんでした。（%s）", label)), nil
+	}
+	if err := p.plugin.saveCustomStamps(remains); err != nil {
+		return nil, err
+	}
+
+	return p.plugin.createCommandResponse(fmt.Sprintf("スタンプを削除しました。（%s）", label)), nil
+}
+
+// findLatestImage チャンネルにユーザが直前に投稿した画像を探す
+func (p *peerStampUsecase) findLatestImage(channelID string, userID string) (*model.FileInfo, *model.AppError) {
+	postList, err := p.plugin.API.GetPostsForChannel(channelID, 0, stampSearchPostsPerPage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, postID := range postList.Order {
+		post := postList.Posts[postID]
+		if post.UserId != userID || post.DeleteAt != 0 {
+			continue
+		}
+		for _, fileID := range post.FileIds {
+			fileInfo, err := p.plugin.API.GetFileInfo(fileID)
+			if err != nil {
+				return nil, err
+			}
+			if fileInfo.IsImage() {
+				return fileInfo, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
@@ -17,6 +17,24 @@ type Plugin struct {
 
 	configuration *configuration
 
+	stampImagesLock sync.RWMutex
+
+	stampImages map[string]*stampImage
+
+	resizedStampImages map[string]*stampImage
+
+	emojiStampLock sync.RWMutex
+
+	emojiStampOptions []*model.PostActionOptions
+
+	emojiStampExpiresAt time.Time
+
+	postIndexLock sync.Mutex
+
+	userCacheLock sync.RWMutex
+
+	userCache map[string]*cachedUser
+
 	run bool
 }
 
@@ -32,10 +50,10 @@ func (p *Plugin) waitForDebuggerAttach() {
 	p.run = true
 }
 
-func (p *Plugin) createErrorCommandResponse(errorMessage string) *model.CommandResponse {
+func (p *Plugin) createCommandResponse(text string) *model.CommandResponse {
 	return &model.CommandResponse{
 		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
-		Text:         errorMessage,
+		Text:         text,
 		Username:     p.getBotDisplayName(),
 		IconURL:      p.getBotImageURL(),
 	}
@@ -52,9 +70,25 @@ func (p *Plugin) getBotImageURL() string {
 	return p.getUserProfileImageURL(bot.UserId)
 }
 
+// getUserDisplayName 渡されたユーザの表示名を作る（渡されたユーザでユーザディレクトリのキャッシュも更新する）
 func (p *Plugin) getUserDisplayName(user model.User) string {
-	//p.API.GetConfig().ServiceSettings
-	return user.GetDisplayName(model.SHOW_NICKNAME_FULLNAME)
+	p.cacheUsers([]*model.User{&user})
+	return formatUserDisplayName(user)
+}
+
+// getUserLocation ユーザがMattermostで設定したタイムゾーンを取得する
+// 未設定または不明なタイムゾーンの場合はサーバのタイムゾーン
+func (p *Plugin) getUserLocation(user model.User) *time.Location {
+	timezone := user.GetPreferredTimezone()
+	if timezone == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		p.API.LogWarn("Failed to load user timezone", "timezone", timezone, "err", err.Error())
+		return time.Local
+	}
+	return location
 }
 
 func (p *Plugin) getBotDisplayName() string {
@@ -64,14 +98,23 @@ func (p *Plugin) getBotDisplayName() string {
 }
 
 func (p *Plugin) getPermanentLinkURL(teamID string, postID string) (string, error) {
+	prefix, err := p.getPermanentLinkURLPrefix(teamID)
+	if err != nil {
+		return "", err
+	}
+	return prefix + postID, nil
+}
+
+// getPermanentLinkURLPrefix 投稿のIDを付ければパーマリンクになるURLを取得する
+// 同じチームの投稿のリンクを沢山作る場合は、これを使ってチームと設定の取得を１回で済ませる
+func (p *Plugin) getPermanentLinkURLPrefix(teamID string) (string, error) {
 	var team *model.Team
 	team, err := p.API.GetTeam(teamID)
 	if err != nil {
 		return "", errors.Cause(err)
 	}
 	siteURL := p.API.GetConfig().ServiceSettings.SiteURL
-	permaLinkURL := fmt.Sprintf("%s/%s/pl/%s", *siteURL, team.Name, postID)
-	return permaLinkURL, nil
+	return fmt.Sprintf("%s/%s/pl/", *siteURL, team.Name), nil
 }
 
 func (p *Plugin) getServerHTTPURL(path string) string {
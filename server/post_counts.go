@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+)
+
+// postCounts ピア投稿を人毎、ハッシュタグ毎に数えた結果
+type postCounts struct {
+	From     map[string]int `json:"from"`     //褒めた回数
+	To       map[string]int `json:"to"`       //褒められた回数
+	Reactors map[string]int `json:"reactors"` //リアクションした回数
+	Hashtags map[string]int `json:"hashtags"` //ハッシュタグの使用回数
+	Stamps   map[string]int `json:"stamps"`   //スタンプの使用回数
+
+	ToHashtags map[string]map[string]int `json:"to_hashtags"` //褒められた人毎のハッシュタグの使用回数（スコアの計算用）
+	ToStamps   map[string]map[string]int `json:"to_stamps"`   //褒められた人毎の贈られたスタンプの回数
+}
+
+func newPostCounts() *postCounts {
+	return &postCounts{
+		From:     map[string]int{},
+		To:       map[string]int{},
+		Reactors: map[string]int{},
+		Hashtags: map[string]int{},
+		Stamps:   map[string]int{},
+
+		ToHashtags: map[string]map[string]int{},
+		ToStamps:   map[string]map[string]int{},
+	}
+}
+
+// add ピア投稿を１件数える
+// fromToは先頭がfrom、以降は全てto（複数人宛ての投稿は宛先毎に数える）
+func (c *postCounts) add(fromTo string, hashtags string, stamp string, reactorIDs []string) {
+	c.count(fromTo, hashtags, stamp, reactorIDs, 1)
+}
+
+// remove 数えたピア投稿を１件差し引く（投稿の削除やリアクションの変更の反映用）
+func (c *postCounts) remove(fromTo string, hashtags string, stamp string, reactorIDs []string) {
+	c.count(fromTo, hashtags, stamp, reactorIDs, -1)
+}
+
+func (c *postCounts) count(fromTo string, hashtags string, stamp string, reactorIDs []string, n int) {
+	ids := strings.Fields(fromTo)
+	if len(ids) < 2 {
+		return //本来あり得ない
+	}
+
+	addCount(c.From, ids[0], n)
+	tags := strings.Fields(hashtags)
+	for _, toID := range ids[1:] {
+		addCount(c.To, toID, n)
+		for _, tag := range tags {
+			addNestedCount(c.ToHashtags, toID, tag, n)
+		}
+		if stamp != "" {
+			addNestedCount(c.ToStamps, toID, stamp, n) //宛先毎に贈られたスタンプを数える
+		}
+	}
+	if stamp != "" {
+		addCount(c.Stamps, stamp, n)
+	}
+	for _, tag := range tags {
+		addCount(c.Hashtags, tag, n)
+	}
+	for _, userID := range reactorIDs {
+		addCount(c.Reactors, userID, n)
+	}
+}
+
+// merge 別に数えた結果を合計する
+func (c *postCounts) merge(other *postCounts) {
+	for _, pair := range []struct{ to, from map[string]int }{
+		{c.From, other.From},
+		{c.To, other.To},
+		{c.Reactors, other.Reactors},
+		{c.Hashtags, other.Hashtags},
+		{c.Stamps, other.Stamps},
+	} {
+		for key, count := range pair.from {
+			addCount(pair.to, key, count)
+		}
+	}
+	for toID, tagCounts := range other.ToHashtags {
+		for tag, count := range tagCounts {
+			addNestedCount(c.ToHashtags, toID, tag, count)
+		}
+	}
+	for toID, stampCounts := range other.ToStamps {
+		for stamp, count := range stampCounts {
+			addNestedCount(c.ToStamps, toID, stamp, count)
+		}
+	}
+}
+
+// addCount 回数を足す（0になった場合はランキングに出さないよう取り除く）
+func addCount(countMap map[string]int, key string, n int) {
+	countMap[key] += n
+	if countMap[key] == 0 {
+		delete(countMap, key)
+	}
+}
+
+func addNestedCount(countMap map[string]map[string]int, key string, nestedKey string, n int) {
+	if _, ok := countMap[key]; !ok {
+		countMap[key] = map[string]int{}
+	}
+	addCount(countMap[key], nestedKey, n)
+	if len(countMap[key]) == 0 {
+		delete(countMap, key)
+	}
+}
+
+// scores ハッシュタグの重みから、褒められた人毎とハッシュタグ毎の重み付きスコアを求める
+// 投稿のスコアはその投稿のハッシュタグの重みの合計
+func (c *postCounts) scores(getWeight func(tag string) float64) (toScores map[string]float64, hashtagScores map[string]float64) {
+	toScores = map[string]float64{}
+	for toID, tagCounts := range c.ToHashtags {
+		for tag, count := range tagCounts {
+			toScores[toID] += getWeight(tag) * float64(count)
+		}
+	}
+
+	hashtagScores = map[string]float64{}
+	for tag, count := range c.Hashtags {
+		hashtagScores[tag] = getWeight(tag) * float64(count)
+	}
+
+	return toScores, hashtagScores
+}
+
+// mergeHashtags 別名のハッシュタグの回数を正式なハッシュタグにまとめる
+func (c *postCounts) mergeHashtags(getCanonical func(tag string) string) {
+	c.Hashtags = mergeCountMap(c.Hashtags, getCanonical)
+	for toID, tagCounts := range c.ToHashtags {
+		c.ToHashtags[toID] = mergeCountMap(tagCounts, getCanonical)
+	}
+}
+
+func mergeCountMap(countMap map[string]int, getKey func(key string) string) map[string]int {
+	merged := map[string]int{}
+	for key, count := range countMap {
+		merged[getKey(key)] += count
+	}
+	return merged
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPostCountsAdd(t *testing.T) {
+	type peerPost struct {
+		fromTo     string
+		hashtags   string
+		stamp      string
+		reactorIDs []string
+	}
+
+	tests := []struct {
+		name         string
+		posts        []peerPost
+		wantFrom     map[string]int
+		wantTo       map[string]int
+		wantHashtags map[string]int
+		wantReactors map[string]int
+		wantStamps   map[string]int
+		wantToStamps map[string]map[string]int
+	}{
+		{
+			name:         "１人宛て",
+			posts:        []peerPost{{fromTo: "a b", hashtags: "#迅速な対応"}},
+			wantFrom:     map[string]int{"a": 1},
+			wantTo:       map[string]int{"b": 1},
+			wantHashtags: map[string]int{"#迅速な対応": 1},
+			wantReactors: map[string]int{},
+			wantStamps:   map[string]int{},
+			wantToStamps: map[string]map[string]int{},
+		},
+		{
+			name:         "複数人宛ては宛先毎に数える",
+			posts:        []peerPost{{fromTo: "a b c d", hashtags: "#迅速な対応 #縁の下の力持ち"}},
+			wantFrom:     map[string]int{"a": 1},
+			wantTo:       map[string]int{"b": 1, "c": 1, "d": 1},
+			wantHashtags: map[string]int{"#迅速な対応": 1, "#縁の下の力持ち": 1},
+			wantReactors: map[string]int{},
+			wantStamps:   map[string]int{},
+			wantToStamps: map[string]map[string]int{},
+		},
+		{
+			name: "複数の投稿を合計する",
+			posts: []peerPost{
+				{fromTo: "a b c", hashtags: "#迅速な対応", reactorIDs: []string{"d"}},
+				{fromTo: "b c", hashtags: "#迅速な対応", reactorIDs: []string{"a", "d"}},
+			},
+			wantFrom:     map[string]int{"a": 1, "b": 1},
+			wantTo:       map[string]int{"b": 1, "c": 2},
+			wantHashtags: map[string]int{"#迅速な対応": 2},
+			wantReactors: map[string]int{"a": 1, "d": 2},
+			wantStamps:   map[string]int{},
+			wantToStamps: map[string]map[string]int{},
+		},
+		{
+			name: "スタンプは宛先毎にも数える",
+			posts: []peerPost{
+				{fromTo: "a b c", hashtags: "#迅速な対応", stamp: "/stamp/stamp_9.png"},
+				{fromTo: "a b", hashtags: "#迅速な対応", stamp: "/stamp/stamp_9.png"},
+				{fromTo: "a c", hashtags: "#迅速な対応"},
+			},
+			wantFrom:     map[string]int{"a": 3},
+			wantTo:       map[string]int{"b": 2, "c": 2},
+			wantHashtags: map[string]int{"#迅速な対応": 3},
+			wantReactors: map[string]int{},
+			wantStamps:   map[string]int{"/stamp/stamp_9.png": 2},
+			wantToStamps: map[string]map[string]int{
+				"b": {"/stamp/stamp_9.png": 2},
+				"c": {"/stamp/stamp_9.png": 1},
+			},
+		},
+		{
+			name:         "宛先の無い投稿は数えない",
+			posts:        []peerPost{{fromTo: "a", hashtags: "#迅速な対応"}},
+			wantFrom:     map[string]int{},
+			wantTo:       map[string]int{},
+			wantHashtags: map[string]int{},
+			wantReactors: map[string]int{},
+			wantStamps:   map[string]int{},
+			wantToStamps: map[string]map[string]int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts := newPostCounts()
+			for _, post := range test.posts {
+				counts.add(post.fromTo, post.hashtags, post.stamp, post.reactorIDs)
+			}
+			if !reflect.DeepEqual(counts.From, test.wantFrom) {
+				t.Errorf("From = %v, want %v", counts.From, test.wantFrom)
+			}
+			if !reflect.DeepEqual(counts.To, test.wantTo) {
+				t.Errorf("To = %v, want %v", counts.To, test.wantTo)
+			}
+			if !reflect.DeepEqual(counts.Hashtags, test.wantHashtags) {
+				t.Errorf("Hashtags = %v, want %v", counts.Hashtags, test.wantHashtags)
+			}
+			if !reflect.DeepEqual(counts.Reactors, test.wantReactors) {
+				t.Errorf("Reactors = %v, want %v", counts.Reactors, test.wantReactors)
+			}
+			if !reflect.DeepEqual(counts.Stamps, test.wantStamps) {
+				t.Errorf("Stamps = %v, want %v", counts.Stamps, test.wantStamps)
+			}
+			if !reflect.DeepEqual(counts.ToStamps, test.wantToStamps) {
+				t.Errorf("ToStamps = %v, want %v", counts.ToStamps, test.wantToStamps)
+			}
+		})
+	}
+}
+
+func TestPostCountsScores(t *testing.T) {
+	weights := map[string]float64{"#迅速な対応": 2, "#縁の下の力持ち": 0.5}
+	getWeight := func(tag string) float64 {
+		if weight, ok := weights[tag]; ok {
+			return weight
+		}
+		return 1
+	}
+
+	counts := newPostCounts()
+	counts.add("a b c", "#迅速な対応 #縁の下の力持ち", "", nil)
+	counts.add("b c", "#迅速な対応", "", nil)
+	counts.add("c b", "#組織の壁を超えて", "", nil)
+
+	toScores, hashtagScores := counts.scores(getWeight)
+
+	wantToScores := map[string]float64{"b": 3.5, "c": 4.5}
+	if !reflect.DeepEqual(toScores, wantToScores) {
+		t.Errorf("toScores = %v, want %v", toScores, wantToScores)
+	}
+	wantHashtagScores := map[string]float64{"#迅速な対応": 4, "#縁の下の力持ち": 0.5, "#組織の壁を超えて": 1}
+	if !reflect.DeepEqual(hashtagScores, wantHashtagScores) {
+		t.Errorf("hashtagScores = %v, want %v", hashtagScores, wantHashtagScores)
+	}
+}
+
+func TestPostCountsMergeHashtags(t *testing.T) {
+	aliases := map[string]string{"#スピード対応": "#迅速な対応"}
+	getCanonical := func(tag string) string {
+		if canonical, ok := aliases[tag]; ok {
+			return canonical
+		}
+		return tag
+	}
+
+	counts := newPostCounts()
+	counts.add("a b", "#迅速な対応", "", nil)
+	counts.add("a b", "#スピード対応 #縁の下の力持ち", "", nil)
+	counts.mergeHashtags(getCanonical)
+
+	wantHashtags := map[string]int{"#迅速な対応": 2, "#縁の下の力持ち": 1}
+	if !reflect.DeepEqual(counts.Hashtags, wantHashtags) {
+		t.Errorf("Hashtags = %v, want %v", counts.Hashtags, wantHashtags)
+	}
+	wantToHashtags := map[string]map[string]int{"b": wantHashtags}
+	if !reflect.DeepEqual(counts.ToHashtags, wantToHashtags) {
+		t.Errorf("ToHashtags = %v, want %v", counts.ToHashtags, wantToHashtags)
+	}
+}
+
+func TestPostCountsMerge(t *testing.T) {
+	day1 := newPostCounts()
+	day1.add("a b", "#迅速な対応", "/stamp/stamp_9.png", []string{"c"})
+	day2 := newPostCounts()
+	day2.add("b a c", "#迅速な対応", "", nil)
+	day2.add("a c", "#縁の下の力持ち", "", nil)
+	day2.remove("a c", "#縁の下の力持ち", "", nil)
+
+	counts := newPostCounts()
+	counts.merge(day1)
+	counts.merge(day2)
+
+	want := newPostCounts()
+	want.add("a b", "#迅速な対応", "/stamp/stamp_9.png", []string{"c"})
+	want.add("b a c", "#迅速な対応", "", nil)
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("merge() = %+v, want %+v", counts, want)
+	}
+}
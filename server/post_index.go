@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	postIndexStateKeyPrefix = "index-state-"
+	postIndexMonthKeyPrefix = "index-month-"
+
+	// postIndexVersion 集計の形式が変わった場合は上げる（古い形式の集計は作り直す）
+	postIndexVersion = 1
+
+	postIndexMonthLayout  = "200601"
+	postIndexDayLayout    = "20060102"
+	postIndexPostsPerPage = 200
+	postIndexKeysPerPage  = 1000
+	postsSinceLimit       = 1000 //GetPostsSinceが一度に返す最大件数
+
+	// postIndexSyncMargin 更新日時が付いてから遅れてコミットされた投稿を取りこぼさないよう、前回の集計より少し前から取得し直す
+	postIndexSyncMargin = 5 * time.Minute
+	// postIndexUpdateRetries 他のノードと同時に集計を更新した場合に読み直す回数
+	postIndexUpdateRetries = 10
+)
+
+// indexedPost 日毎の集計に数えたピア投稿の内容
+// 投稿の削除やリアクションの変更の際に数えた分を差し引くため、また集計期間の端の日を投稿の時刻で数えるために残す
+type indexedPost struct {
+	CreateAt   int64    `json:"create_at"`
+	FromTo     string   `json:"from_to"`
+	Hashtags   string   `json:"hashtags"`
+	Stamp      string   `json:"stamp"`
+	ReactorIDs []string `json:"reactors"`
+}
+
+// postIndexDay １日分（UTC）のピア投稿の集計
+type postIndexDay struct {
+	Counts *postCounts             `json:"counts"`
+	Posts  map[string]*indexedPost `json:"posts"`
+}
+
+// postIndexMonth １ヶ月分の日毎の集計（KVの読み書きは月単位でまとめる）
+type postIndexMonth struct {
+	Days map[string]*postIndexDay `json:"days"`
+}
+
+// postIndexState チャンネル毎の集計の進み具合
+// SyncedAtまでに更新された投稿（作成、削除、リアクションの追加・削除）は集計済み
+type postIndexState struct {
+	Version  int   `json:"version"`
+	SyncedAt int64 `json:"synced_at"`
+}
+
+func postIndexMonthKey(channelID string, month string) string {
+	return postIndexMonthKeyPrefix + channelID + "-" + month
+}
+
+func postIndexTimeOf(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
+}
+
+// add 投稿を日毎の集計に反映する（nilの場合は集計から除く）
+// 既に数えた投稿は一度差し引いてから数え直すため、同じ投稿を何度反映しても結果は変わらない
+func (m *postIndexMonth) add(postID string, day string, post *indexedPost) {
+	indexDay, ok := m.Days[day]
+	if !ok {
+		if post == nil {
+			return //元から無い
+		}
+		indexDay = &postIndexDay{
+			Counts: newPostCounts(),
+			Posts:  map[string]*indexedPost{},
+		}
+		m.Days[day] = indexDay
+	}
+
+	if old, ok := indexDay.Posts[postID]; ok {
+		indexDay.Counts.remove(old.FromTo, old.Hashtags, old.Stamp, old.ReactorIDs)
+		delete(indexDay.Posts, postID)
+	}
+	if post != nil {
+		indexDay.Counts.add(post.FromTo, post.Hashtags, post.Stamp, post.ReactorIDs)
+		indexDay.Posts[postID] = post
+	}
+
+	if len(indexDay.Posts) == 0 {
+		delete(m.Days, day)
+	}
+}
+
+// countPeriod 集計期間に投稿されたピア投稿を数える
+// 全体が集計期間に含まれる日は日毎の集計をそのまま使い、集計期間の端の日だけ投稿の時刻で数える
+func (m *postIndexMonth) countPeriod(period *reportPeriod, counts *postCounts) {
+	for day, indexDay := range m.Days {
+		start, err := time.ParseInLocation(postIndexDayLayout, day, time.UTC)
+		if err != nil {
+			continue //本来あり得ない
+		}
+		end := start.AddDate(0, 0, 1)
+		if !end.After(period.from) || !start.Before(period.to) {
+			continue //期間外
+		}
+		if !start.Before(period.from) && !end.After(period.to) {
+			counts.merge(indexDay.Counts)
+			continue
+		}
+		for _, post := range indexDay.Posts {
+			if period.contains(post.CreateAt) {
+				counts.add(post.FromTo, post.Hashtags, post.Stamp, post.ReactorIDs)
+			}
+		}
+	}
+}
+
+// getPostCounts 集計期間に投稿されたピア投稿を日毎の集計から数える
+func (p *Plugin) getPostCounts(channelID string, period *reportPeriod) (*postCounts, *model.AppError) {
+	counts := newPostCounts()
+	from := period.from.UTC()
+	from = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for month := from; month.Before(period.to); month = month.AddDate(0, 1, 0) {
+		_, indexMonth, err := p.getPostIndexMonth(channelID, month.Format(postIndexMonthLayout))
+		if err != nil {
+			return nil, err
+		}
+		indexMonth.countPeriod(period, counts)
+	}
+	return counts, nil
+}
+
+// preparePostIndex レポートの前に、前回から更新された投稿を集計に反映する
+// まだ集計が無い場合や差分を取得しきれない場合は、全ての投稿から作り直す
+func (p *Plugin) preparePostIndex(channelID string) *model.AppError {
+	synced, err := p.syncPostIndex(channelID)
+	if err != nil {
+		return err
+	}
+	if synced {
+		return nil
+	}
+	return p.rebuildPostIndex(channelID)
+}
+
+// syncPostIndex 前回の集計から更新されたピア投稿を集計に反映する
+// 投稿の削除、リアクションの追加・削除でも投稿の更新日時が変わるため、更新日時で差分を求められる
+// まだ集計が無い場合や、更新が多すぎて差分を取得しきれない場合は何もせずにfalseを返す（作り直しは呼び出し元に任せる）
+func (p *Plugin) syncPostIndex(channelID string) (bool, *model.AppError) {
+	p.postIndexLock.Lock()
+	defer p.postIndexLock.Unlock()
+
+	_, state, err := p.getPostIndexState(channelID)
+	if err != nil {
+		return false, err
+	}
+	if state == nil || state.Version != postIndexVersion {
+		return false, nil //まだ集計していないチャンネル、または古い形式の集計
+	}
+
+	//同じ投稿を集計し直しても結果は変わらないため、少し前から取得し直す
+	since := state.SyncedAt - int64(postIndexSyncMargin/time.Millisecond)
+	if since < 0 {
+		since = 0
+	}
+	postList, err := p.API.GetPostsSince(channelID, since)
+	if err != nil {
+		return false, err
+	}
+	if len(postList.Order) >= postsSinceLimit {
+		p.API.LogWarn("Too many updated posts to sync post index", "channel_id", channelID)
+		return false, nil
+	}
+
+	syncedAt := state.SyncedAt
+	posts := []*model.Post{}
+	for _, postID := range postList.Order {
+		post := postList.Posts[postID]
+		posts = append(posts, post)
+		if post.UpdateAt > syncedAt {
+			syncedAt = post.UpdateAt
+		}
+	}
+	if err := p.indexPosts(channelID, posts); err != nil {
+		return false, err
+	}
+	if err := p.updatePostIndexState(channelID, syncedAt); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// rebuildPostIndex チャンネルの集計を全て作り直す
+func (p *Plugin) rebuildPostIndex(channelID string) *model.AppError {
+	p.postIndexLock.Lock()
+	defer p.postIndexLock.Unlock()
+
+	//古い集計を削除
+	keys := []string{}
+	for page := 0; ; page++ {
+		pageKeys, err := p.API.KVList(page, postIndexKeysPerPage)
+		if err != nil {
+			return err
+		}
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, postIndexMonthKeyPrefix+channelID+"-") {
+				keys = append(keys, key)
+			}
+		}
+		if len(pageKeys) < postIndexKeysPerPage {
+			break
+		}
+	}
+	for _, key := range keys {
+		if err := p.API.KVDelete(key); err != nil {
+			return err
+		}
+	}
+
+	//全ての投稿を集計する
+	syncedAt := int64(0)
+	for page := 0; ; page++ {
+		postList, err := p.API.GetPostsForChannel(channelID, page, postIndexPostsPerPage)
+		if err != nil {
+			return err
+		}
+		if len(postList.Order) == 0 {
+			break
+		}
+		posts := []*model.Post{}
+		for _, postID := range postList.Order {
+			post := postList.Posts[postID]
+			posts = append(posts, post)
+			if post.UpdateAt > syncedAt {
+				syncedAt = post.UpdateAt
+			}
+		}
+		if err := p.indexPosts(channelID, posts); err != nil {
+			return err
+		}
+	}
+
+	return p.updatePostIndexState(channelID, syncedAt)
+}
+
+// indexPosts 投稿を日毎の集計に反映する（削除済みの投稿は集計から除く）
+func (p *Plugin) indexPosts(channelID string, posts []*model.Post) *model.AppError {
+	//反映する投稿を月毎、日毎に分ける（nilは集計から除く投稿）
+	months := map[string]map[string]map[string]*indexedPost{}
+	for _, post := range posts {
+		if post.Type != "custom_peer-post" {
+			continue //違う投稿
+		}
+		createAt := postIndexTimeOf(post.CreateAt)
+		month := createAt.Format(postIndexMonthLayout)
+		day := createAt.Format(postIndexDayLayout)
+		if _, ok := months[month]; !ok {
+			months[month] = map[string]map[string]*indexedPost{}
+		}
+		if _, ok := months[month][day]; !ok {
+			months[month][day] = map[string]*indexedPost{}
+		}
+
+		if post.DeleteAt != 0 {
+			months[month][day][post.Id] = nil //削除済み
+			continue
+		}
+		indexed, err := p.createIndexedPost(post)
+		if err != nil {
+			return err
+		}
+		months[month][day][post.Id] = indexed
+	}
+
+	for month, days := range months {
+		if err := p.updatePostIndexMonth(channelID, month, days); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) createIndexedPost(post *model.Post) (*indexedPost, *model.AppError) {
+	fromTo, ok := post.Props["from-to"].(string)
+	if !ok || fromTo == "" {
+		return nil, nil //本来あり得ない
+	}
+
+	indexed := &indexedPost{
+		CreateAt:   post.CreateAt,
+		FromTo:     fromTo,
+		Hashtags:   post.Hashtags,
+		Stamp:      p.getPostStamp(post),
+		ReactorIDs: []string{},
+	}
+
+	if post.HasReactions {
+		reactions, err := p.API.GetReactions(post.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, reaction := range reactions {
+			indexed.ReactorIDs = append(indexed.ReactorIDs, reaction.UserId)
+		}
+	}
+	return indexed, nil
+}
+
+// getPostIndexMonth １ヶ月分の集計を取得する（KVCompareAndSetで使うため、保存されていたデータも返す）
+func (p *Plugin) getPostIndexMonth(channelID string, month string) ([]byte, *postIndexMonth, *model.AppError) {
+	data, appErr := p.API.KVGet(postIndexMonthKey(channelID, month))
+	if appErr != nil {
+		return nil, nil, appErr
+	}
+
+	indexMonth := &postIndexMonth{}
+	if data != nil {
+		if err := json.Unmarshal(data, indexMonth); err != nil {
+			return nil, nil, model.NewAppError("getPostIndexMonth", "peerpost.post_index.unmarshal.app_error", nil, err.Error(), http.StatusInternalServerError)
+		}
+	}
+	if indexMonth.Days == nil {
+		indexMonth.Days = map[string]*postIndexDay{}
+	}
+	return data, indexMonth, nil
+}
+
+// updatePostIndexMonth １ヶ月分の集計に日毎に分けた投稿を反映する
+// 他のノードが同時に更新した場合は、読み込んだ時から変わっていれば読み直して反映し直す
+func (p *Plugin) updatePostIndexMonth(channelID string, month string, days map[string]map[string]*indexedPost) *model.AppError {
+	key := postIndexMonthKey(channelID, month)
+	for i := 0; i < postIndexUpdateRetries; i++ {
+		oldData, indexMonth, appErr := p.getPostIndexMonth(channelID, month)
+		if appErr != nil {
+			return appErr
+		}
+
+		for day, posts := range days {
+			for postID, post := range posts {
+				indexMonth.add(postID, day, post)
+			}
+		}
+
+		var ok bool
+		if len(indexMonth.Days) == 0 {
+			if oldData == nil {
+				return nil //元から無い
+			}
+			ok, appErr = p.API.KVCompareAndDelete(key, oldData)
+		} else {
+			newData, err := json.Marshal(indexMonth)
+			if err != nil {
+				return model.NewAppError("updatePostIndexMonth", "peerpost.post_index.marshal.app_error", nil, err.Error(), http.StatusInternalServerError)
+			}
+			if bytes.Equal(oldData, newData) {
+				return nil //変更なし
+			}
+			ok, appErr = p.API.KVCompareAndSet(key, oldData, newData)
+		}
+		if appErr != nil {
+			return appErr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return model.NewAppError("updatePostIndexMonth", "peerpost.post_index.conflict.app_error", nil, "key="+key, http.StatusConflict)
+}
+
+// getPostIndexState 集計の進み具合を取得する（KVCompareAndSetで使うため、保存されていたデータも返す）
+func (p *Plugin) getPostIndexState(channelID string) ([]byte, *postIndexState, *model.AppError) {
+	data, appErr := p.API.KVGet(postIndexStateKeyPrefix + channelID)
+	if appErr != nil || data == nil {
+		return nil, nil, appErr
+	}
+
+	state := &postIndexState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, nil, model.NewAppError("getPostIndexState", "peerpost.post_index.unmarshal.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	return data, state, nil
+}
+
+// updatePostIndexState 集計の進み具合を保存する
+// 他のノードが先に進めていた場合は戻さない
+func (p *Plugin) updatePostIndexState(channelID string, syncedAt int64) *model.AppError {
+	key := postIndexStateKeyPrefix + channelID
+	for i := 0; i < postIndexUpdateRetries; i++ {
+		oldData, state, appErr := p.getPostIndexState(channelID)
+		if appErr != nil {
+			return appErr
+		}
+		if state != nil && state.Version == postIndexVersion && state.SyncedAt >= syncedAt {
+			return nil
+		}
+
+		newData, err := json.Marshal(&postIndexState{Version: postIndexVersion, SyncedAt: syncedAt})
+		if err != nil {
+			return model.NewAppError("updatePostIndexState", "peerpost.post_index.marshal.app_error", nil, err.Error(), http.StatusInternalServerError)
+		}
+		ok, appErr := p.API.KVCompareAndSet(key, oldData, newData)
+		if appErr != nil {
+			return appErr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return model.NewAppError("updatePostIndexState", "peerpost.post_index.conflict.app_error", nil, "key="+key, http.StatusConflict)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPostIndexMonthAdd(t *testing.T) {
+	millis := func(day int, hour int) int64 {
+		return time.Date(2019, time.May, day, hour, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	}
+	post := &indexedPost{CreateAt: millis(1, 10), FromTo: "a b", Hashtags: "#迅速な対応", ReactorIDs: []string{}}
+	reacted := &indexedPost{CreateAt: millis(1, 10), FromTo: "a b", Hashtags: "#迅速な対応", ReactorIDs: []string{"c"}}
+	other := &indexedPost{CreateAt: millis(1, 12), FromTo: "b a", Hashtags: "#縁の下の力持ち", ReactorIDs: []string{}}
+
+	type update struct {
+		postID string
+		post   *indexedPost
+	}
+	tests := []struct {
+		name         string
+		updates      []update
+		wantPosts    int
+		wantTo       map[string]int
+		wantReactors map[string]int
+	}{
+		{
+			name:         "追加",
+			updates:      []update{{"post1", post}, {"post2", other}},
+			wantPosts:    2,
+			wantTo:       map[string]int{"a": 1, "b": 1},
+			wantReactors: map[string]int{},
+		},
+		{
+			name:         "同じ投稿を何度反映しても変わらない",
+			updates:      []update{{"post1", post}, {"post1", post}, {"post1", post}},
+			wantPosts:    1,
+			wantTo:       map[string]int{"b": 1},
+			wantReactors: map[string]int{},
+		},
+		{
+			name:         "リアクションの追加",
+			updates:      []update{{"post1", post}, {"post1", reacted}},
+			wantPosts:    1,
+			wantTo:       map[string]int{"b": 1},
+			wantReactors: map[string]int{"c": 1},
+		},
+		{
+			name:         "削除",
+			updates:      []update{{"post1", reacted}, {"post2", other}, {"post1", nil}},
+			wantPosts:    1,
+			wantTo:       map[string]int{"a": 1},
+			wantReactors: map[string]int{},
+		},
+		{
+			name:      "全て削除した日は残さない",
+			updates:   []update{{"post1", post}, {"post1", nil}, {"post3", nil}},
+			wantPosts: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			indexMonth := &postIndexMonth{Days: map[string]*postIndexDay{}}
+			for _, u := range test.updates {
+				indexMonth.add(u.postID, "20190501", u.post)
+			}
+
+			indexDay, ok := indexMonth.Days["20190501"]
+			if test.wantPosts == 0 {
+				if ok {
+					t.Errorf("Days = %v, want empty", indexMonth.Days)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("Days has no 20190501")
+			}
+			if len(indexDay.Posts) != test.wantPosts {
+				t.Errorf("len(Posts) = %d, want %d", len(indexDay.Posts), test.wantPosts)
+			}
+			if !reflect.DeepEqual(indexDay.Counts.To, test.wantTo) {
+				t.Errorf("To = %v, want %v", indexDay.Counts.To, test.wantTo)
+			}
+			if !reflect.DeepEqual(indexDay.Counts.Reactors, test.wantReactors) {
+				t.Errorf("Reactors = %v, want %v", indexDay.Counts.Reactors, test.wantReactors)
+			}
+		})
+	}
+}
+
+func TestPostIndexMonthCountPeriod(t *testing.T) {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	add := func(indexMonth *postIndexMonth, postID string, createAt time.Time, fromTo string) {
+		post := &indexedPost{
+			CreateAt:   createAt.UnixNano() / int64(time.Millisecond),
+			FromTo:     fromTo,
+			Hashtags:   "#迅速な対応",
+			ReactorIDs: []string{},
+		}
+		indexMonth.add(postID, createAt.UTC().Format(postIndexDayLayout), post)
+	}
+
+	indexMonth := &postIndexMonth{Days: map[string]*postIndexDay{}}
+	add(indexMonth, "post1", time.Date(2019, time.May, 1, 10, 0, 0, 0, time.UTC), "a b") //JSTで5/1
+	add(indexMonth, "post2", time.Date(2019, time.May, 1, 20, 0, 0, 0, time.UTC), "a c") //JSTで5/2
+	add(indexMonth, "post3", time.Date(2019, time.May, 2, 12, 0, 0, 0, time.UTC), "a d") //JSTで5/2
+	add(indexMonth, "post4", time.Date(2019, time.May, 2, 16, 0, 0, 0, time.UTC), "a e") //JSTで5/3
+	add(indexMonth, "post5", time.Date(2019, time.May, 3, 1, 0, 0, 0, time.UTC), "a f")  //JSTで5/3
+
+	tests := []struct {
+		name   string
+		period *reportPeriod
+		wantTo map[string]int
+	}{
+		{
+			name: "UTCの日の区切りと同じ期間",
+			period: &reportPeriod{
+				from: time.Date(2019, time.May, 1, 0, 0, 0, 0, time.UTC),
+				to:   time.Date(2019, time.May, 3, 0, 0, 0, 0, time.UTC),
+			},
+			wantTo: map[string]int{"b": 1, "c": 1, "d": 1, "e": 1},
+		},
+		{
+			name: "タイムゾーンが違う期間は端の日を投稿の時刻で数える",
+			period: &reportPeriod{
+				from: time.Date(2019, time.May, 2, 0, 0, 0, 0, jst),
+				to:   time.Date(2019, time.May, 3, 0, 0, 0, 0, jst),
+			},
+			wantTo: map[string]int{"c": 1, "d": 1},
+		},
+		{
+			name: "期間内に投稿が無い",
+			period: &reportPeriod{
+				from: time.Date(2019, time.May, 10, 0, 0, 0, 0, jst),
+				to:   time.Date(2019, time.May, 11, 0, 0, 0, 0, jst),
+			},
+			wantTo: map[string]int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts := newPostCounts()
+			indexMonth.countPeriod(test.period, counts)
+			if !reflect.DeepEqual(counts.To, test.wantTo) {
+				t.Errorf("To = %v, want %v", counts.To, test.wantTo)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	recentChoicesKeyPrefix = "recent-"
+	recentChoicesMaxCount  = 5
+)
+
+// recentChoices ユーザが最近選択したスタンプとハッシュタグ（新しい順）
+type recentChoices struct {
+	Stamps   []string `json:"stamps"`
+	Hashtags []string `json:"hashtags"`
+}
+
+func (p *Plugin) getRecentChoices(userID string) (*recentChoices, *model.AppError) {
+	choices := &recentChoices{
+		Stamps:   []string{},
+		Hashtags: []string{},
+	}
+
+	data, appErr := p.API.KVGet(recentChoicesKeyPrefix + userID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if data == nil {
+		return choices, nil
+	}
+	if err := json.Unmarshal(data, choices); err != nil {
+		//壊れている場合は履歴なしとして扱う
+		p.API.LogWarn("Failed to unmarshal recent choices", "err", err.Error())
+	}
+	return choices, nil
+}
+
+func (p *Plugin) saveRecentChoices(userID string, stamp string, hashtags []string) *model.AppError {
+	choices, appErr := p.getRecentChoices(userID)
+	if appErr != nil {
+		return appErr
+	}
+
+	if stamp != "" {
+		choices.Stamps = prependRecent(choices.Stamps, stamp)
+	}
+	//先頭のハッシュタグが最も新しくなるよう逆順に追加
+	for i := len(hashtags) - 1; i >= 0; i-- {
+		choices.Hashtags = prependRecent(choices.Hashtags, hashtags[i])
+	}
+
+	data, err := json.Marshal(choices)
+	if err != nil {
+		return model.NewAppError("saveRecentChoices", "peerpost.recent_choices.marshal.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	return p.API.KVSet(recentChoicesKeyPrefix+userID, data)
+}
+
+// prependRecent 値を先頭に移動し、最大数を超えた古いものを捨てる
+func prependRecent(values []string, value string) []string {
+	recent := []string{value}
+	for _, v := range values {
+		if v != value && len(recent) < recentChoicesMaxCount {
+			recent = append(recent, v)
+		}
+	}
+	return recent
+}
+
+// sortOptionsByRecent 最近選択したものを先頭に並べ替える（元のスライスは変更しない）
+func sortOptionsByRecent(options []*model.PostActionOptions, recent []string) []*model.PostActionOptions {
+	sorted := []*model.PostActionOptions{}
+	for _, value := range recent {
+		if option := findOptionByValue(options, value); option != nil {
+			sorted = append(sorted, option)
+		}
+	}
+	for _, option := range options {
+		if !containsString(recent, option.Value) {
+			sorted = append(sorted, option)
+		}
+	}
+	return sorted
+}
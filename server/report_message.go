@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// reportMessageMaxRunes １つの投稿に書けるレポートの最大文字数（CreatePostはこれより長いメッセージを受け付けない）
+const reportMessageMaxRunes = model.POST_MESSAGE_MAX_RUNES_V2
+
+// splitReportMessage 投稿できる長さを超えるレポートを段落の区切りで複数のメッセージに分ける
+// １つの段落（表）が長すぎる場合は行の区切りで分け、表の続きには見出しの行を付け直す
+func splitReportMessage(message string, maxRunes int) []string {
+	messages := []string{}
+	current := ""
+	add := func(block string) {
+		if current != "" && utf8.RuneCountInString(current)+2+utf8.RuneCountInString(block) > maxRunes {
+			messages = append(messages, current)
+			current = ""
+		}
+		if current == "" {
+			current = block
+		} else {
+			current += "\n\n" + block
+		}
+	}
+
+	for _, paragraph := range strings.Split(message, "\n\n") {
+		if utf8.RuneCountInString(paragraph) <= maxRunes {
+			add(paragraph)
+			continue
+		}
+		for _, block := range splitReportParagraph(paragraph, maxRunes) {
+			add(block)
+		}
+	}
+	if current != "" {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+// splitReportParagraph 長すぎる段落を行の区切りで分ける
+func splitReportParagraph(paragraph string, maxRunes int) []string {
+	lines := strings.Split(paragraph, "\n")
+
+	//表の場合は見出しと揃えの行を続きにも付ける
+	header := []string{}
+	if len(lines) > 2 && strings.HasPrefix(lines[0], "|") && strings.HasPrefix(lines[1], "|") {
+		header = lines[:2]
+	}
+
+	blocks := []string{}
+	current := []string{}
+	size := 0
+	for _, line := range lines {
+		if len(current) > len(header) && size+1+utf8.RuneCountInString(line) > maxRunes {
+			blocks = append(blocks, strings.Join(current, "\n"))
+			current = append([]string{}, header...)
+			size = utf8.RuneCountInString(strings.Join(header, "\n"))
+		}
+		if len(current) > 0 {
+			size++ //改行
+		}
+		current = append(current, line)
+		size += utf8.RuneCountInString(line)
+	}
+	if len(current) > len(header) {
+		blocks = append(blocks, strings.Join(current, "\n"))
+	}
+	return blocks
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitReportMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		maxRunes int
+		want     []string
+	}{
+		{
+			name:     "短いレポートはそのまま",
+			message:  "集計期間\n\n|名前|回数|\n|:---|---:|\n|A|1|",
+			maxRunes: 100,
+			want:     []string{"集計期間\n\n|名前|回数|\n|:---|---:|\n|A|1|"},
+		},
+		{
+			name:     "段落の区切りで分ける",
+			message:  "あいうえお\n\nかきくけこ\n\nさしすせそ",
+			maxRunes: 12,
+			want:     []string{"あいうえお\n\nかきくけこ", "さしすせそ"},
+		},
+		{
+			name:     "長い表は見出しを付け直して分ける",
+			message:  "|名前|回数|\n|:---|---:|\n|A|1|\n|B|2|\n|C|3|",
+			maxRunes: 28,
+			want: []string{
+				"|名前|回数|\n|:---|---:|\n|A|1|",
+				"|名前|回数|\n|:---|---:|\n|B|2|",
+				"|名前|回数|\n|:---|---:|\n|C|3|",
+			},
+		},
+		{
+			name:     "表ではない長い段落は行の区切りで分ける",
+			message:  "- あいう\n- えお\n- かき",
+			maxRunes: 10,
+			want:     []string{"- あいう\n- えお", "- かき"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := splitReportMessage(test.message, test.maxRunes)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("splitReportMessage() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitReportMessageLength(t *testing.T) {
+	lines := []string{"|名前|回数|", "|:---|---:|"}
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, "|ユーザ名ユーザ名|123|")
+	}
+	message := "集計期間\n\n" + strings.Join(lines, "\n") + "\n\n最後の段落"
+
+	messages := splitReportMessage(message, reportMessageMaxRunes)
+	if len(messages) < 2 {
+		t.Fatalf("splitReportMessage() returned %d messages, want at least 2", len(messages))
+	}
+	for i, m := range messages {
+		if n := utf8.RuneCountInString(m); n > reportMessageMaxRunes {
+			t.Errorf("message %d has %d runes, want at most %d", i, n, reportMessageMaxRunes)
+		}
+	}
+	if !strings.HasSuffix(messages[len(messages)-1], "最後の段落") {
+		t.Errorf("last message does not end with the last paragraph")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// reportPeriod レポートの集計期間（fromを含み、toを含まない）
+type reportPeriod struct {
+	from         time.Time
+	to           time.Time
+	businessDays int
+}
+
+// describe 集計期間を表示用に整形する（終了日は期間に含まれる最後の日）
+func (r *reportPeriod) describe() string {
+	return fmt.Sprintf("%s〜%s（%s、営業日%d日）", r.from.Format("2006/01/02"), r.to.AddDate(0, 0, -1).Format("2006/01/02"), r.from.Location().String(), r.businessDays)
+}
+
+// contains 指定の時刻（ミリ秒）が集計期間に含まれるか確認する
+func (r *reportPeriod) contains(millis int64) bool {
+	return millis >= r.from.Unix()*1000 && millis < r.to.Unix()*1000
+}
+
+// getReportPeriod コマンドの引数から集計期間を求める
+// 引数は「開始日」「開始日 終了日」または期間のキーワード。省略した場合は今週
+func (c *configuration) getReportPeriod(teamID string, args []string, now time.Time) (*reportPeriod, error) {
+	period, err := c.parseReportPeriod(teamID, args, now)
+	if err != nil {
+		return nil, err
+	}
+	period.businessDays = c.getCalendar(teamID).countBusinessDays(period.from, period.to)
+	return period, nil
+}
+
+func (c *configuration) parseReportPeriod(teamID string, args []string, now time.Time) (*reportPeriod, error) {
+	calendar := c.getCalendar(teamID)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	tomorrow := today.AddDate(0, 0, 1)
+	thisWeek := calendar.getWeekStart(today) //チームの週の開始曜日
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	//年度の開始日を求める
+	fiscalYear := time.Date(now.Year(), c.fiscalYearStartMonth, 1, 0, 0, 0, 0, now.Location())
+	if fiscalYear.After(today) {
+		fiscalYear = fiscalYear.AddDate(-1, 0, 0)
+	}
+
+	switch len(args) {
+	case 0:
+		//今週まだ営業日が無い場合（週初めが休日など）は先週
+		if calendar.countBusinessDays(thisWeek, tomorrow) == 0 {
+			return &reportPeriod{from: thisWeek.AddDate(0, 0, -7), to: thisWeek}, nil
+		}
+		return &reportPeriod{from: thisWeek, to: tomorrow}, nil
+	case 1:
+		switch args[0] {
+		case "today":
+			return &reportPeriod{from: today, to: tomorrow}, nil
+		case "this-week":
+			return &reportPeriod{from: thisWeek, to: tomorrow}, nil
+		case "last-week":
+			return &reportPeriod{from: thisWeek.AddDate(0, 0, -7), to: thisWeek}, nil
+		case "this-month":
+			return &reportPeriod{from: thisMonth, to: thisMonth.AddDate(0, 1, 0)}, nil
+		case "last-month":
+			return &reportPeriod{from: thisMonth.AddDate(0, -1, 0), to: thisMonth}, nil
+		case "quarter":
+			//年度の開始月から３ヶ月毎に区切った今の四半期
+			months := (int(now.Month()) - int(c.fiscalYearStartMonth) + 12) % 12
+			quarter := fiscalYear.AddDate(0, months/3*3, 0)
+			return &reportPeriod{from: quarter, to: quarter.AddDate(0, 3, 0)}, nil
+		case "fiscal-year":
+			return &reportPeriod{from: fiscalYear, to: fiscalYear.AddDate(1, 0, 0)}, nil
+		}
+		from, err := parseReportDate(args[0], now.Location())
+		if err != nil {
+			return nil, err
+		}
+		return &reportPeriod{from: from, to: tomorrow}, nil
+	case 2:
+		from, err := parseReportDate(args[0], now.Location())
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseReportDate(args[1], now.Location())
+		if err != nil {
+			return nil, err
+		}
+		if to.Before(from) {
+			return nil, errors.New("終了日は開始日以降の日付を指定してください。\n\n" + commandPeerReportUsage)
+		}
+		return &reportPeriod{from: from, to: to.AddDate(0, 0, 1)}, nil //終了日を含める
+	}
+
+	return nil, errors.New(commandPeerReportUsage)
+}
+
+func parseReportDate(arg string, location *time.Location) (time.Time, error) {
+	date, err := time.ParseInLocation("2006/01/02", arg, location)
+	if err != nil {
+		return date, errors.New("有効な日付ではありません。\n\n" + commandPeerReportUsage)
+	}
+	return date, nil
+}
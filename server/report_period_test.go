@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReportPeriod(t *testing.T) {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, jst)
+	}
+	newConfiguration := func(fiscalYearStartMonth time.Month) *configuration {
+		return &configuration{
+			fiscalYearStartMonth: fiscalYearStartMonth,
+			calendar:             &businessCalendar{weekStart: time.Monday, holidays: []packDate{}},
+		}
+	}
+
+	tests := []struct {
+		name                 string
+		fiscalYearStartMonth time.Month
+		args                 []string
+		now                  time.Time
+		wantFrom             time.Time
+		wantTo               time.Time
+	}{
+		{
+			name:                 "四半期：年度の最初の四半期",
+			fiscalYearStartMonth: time.April,
+			args:                 []string{"quarter"},
+			now:                  time.Date(2019, time.May, 15, 10, 0, 0, 0, jst),
+			wantFrom:             date(2019, time.April, 1),
+			wantTo:               date(2019, time.July, 1),
+		},
+		{
+			name:                 "四半期：四半期の初日",
+			fiscalYearStartMonth: time.April,
+			args:                 []string{"quarter"},
+			now:                  time.Date(2019, time.October, 1, 0, 0, 0, 0, jst),
+			wantFrom:             date(2019, time.October, 1),
+			wantTo:               date(2020, time.January, 1),
+		},
+		{
+			name:                 "四半期：年度の最後の日",
+			fiscalYearStartMonth: time.April,
+			args:                 []string{"quarter"},
+			now:                  time.Date(2020, time.March, 31, 23, 59, 0, 0, jst),
+			wantFrom:             date(2020, time.January, 1),
+			wantTo:               date(2020, time.April, 1),
+		},
+		{
+			name:                 "四半期：年度が10月始まりで年を跨がない四半期",
+			fiscalYearStartMonth: time.October,
+			args:                 []string{"quarter"},
+			now:                  time.Date(2019, time.September, 30, 12, 0, 0, 0, jst),
+			wantFrom:             date(2019, time.July, 1),
+			wantTo:               date(2019, time.October, 1),
+		},
+		{
+			name:                 "四半期：年度が1月始まり",
+			fiscalYearStartMonth: time.January,
+			args:                 []string{"quarter"},
+			now:                  time.Date(2019, time.December, 31, 12, 0, 0, 0, jst),
+			wantFrom:             date(2019, time.October, 1),
+			wantTo:               date(2020, time.January, 1),
+		},
+		{
+			name:                 "年度：年度の最後の日",
+			fiscalYearStartMonth: time.April,
+			args:                 []string{"fiscal-year"},
+			now:                  time.Date(2019, time.March, 31, 23, 59, 0, 0, jst),
+			wantFrom:             date(2018, time.April, 1),
+			wantTo:               date(2019, time.April, 1),
+		},
+		{
+			name:                 "年度：年度の最初の日",
+			fiscalYearStartMonth: time.April,
+			args:                 []string{"fiscal-year"},
+			now:                  time.Date(2019, time.April, 1, 0, 0, 0, 0, jst),
+			wantFrom:             date(2019, time.April, 1),
+			wantTo:               date(2020, time.April, 1),
+		},
+		{
+			name:                 "年度：年度が1月始まり",
+			fiscalYearStartMonth: time.January,
+			args:                 []string{"fiscal-year"},
+			now:                  time.Date(2019, time.December, 31, 12, 0, 0, 0, jst),
+			wantFrom:             date(2019, time.January, 1),
+			wantTo:               date(2020, time.January, 1),
+		},
+		{
+			name:                 "省略：今週の開始日から",
+			fiscalYearStartMonth: time.April,
+			args:                 []string{},
+			now:                  time.Date(2019, time.May, 19, 10, 0, 0, 0, jst),
+			wantFrom:             date(2019, time.May, 13),
+			wantTo:               date(2019, time.May, 20),
+		},
+		{
+			name:                 "期間指定：終了日を含める",
+			fiscalYearStartMonth: time.April,
+			args:                 []string{"2019/04/01", "2019/04/30"},
+			now:                  time.Date(2019, time.May, 15, 10, 0, 0, 0, jst),
+			wantFrom:             date(2019, time.April, 1),
+			wantTo:               date(2019, time.May, 1),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			period, err := newConfiguration(test.fiscalYearStartMonth).parseReportPeriod("", test.args, test.now)
+			if err != nil {
+				t.Fatalf("parseReportPeriod() returned error: %v", err)
+			}
+			if !period.from.Equal(test.wantFrom) || !period.to.Equal(test.wantTo) {
+				t.Errorf("parseReportPeriod() = %v - %v, want %v - %v", period.from, period.to, test.wantFrom, test.wantTo)
+			}
+		})
+	}
+}
+
+func TestParseReportPeriodError(t *testing.T) {
+	c := &configuration{
+		fiscalYearStartMonth: time.April,
+		calendar:             &businessCalendar{weekStart: time.Monday, holidays: []packDate{}},
+	}
+	now := time.Date(2019, time.May, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "不明なキーワード", args: []string{"next-week"}},
+		{name: "不正な日付", args: []string{"2019/13/01"}},
+		{name: "終了日が開始日より前", args: []string{"2019/04/30", "2019/04/01"}},
+		{name: "引数が多すぎる", args: []string{"2019/04/01", "2019/04/30", "2019/05/31"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := c.parseReportPeriod("", test.args, now); err == nil {
+				t.Errorf("parseReportPeriod(%q) returned no error", test.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	_ "image/jpeg" //JPEGのスタンプをデコードするため
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	stampCacheControl = "public, max-age=86400"
+)
+
+// 縮小して返すことができるサイズ（メモリ上に保持する種類を限定するため）
+var stampSizes = []int{32, 48, 64, 96, 128, 192, 256}
+
+// stampImage メモリ上に保持するスタンプ画像
+type stampImage struct {
+	data     []byte
+	mimeType string
+	etag     string
+}
+
+func newStampImage(data []byte) *stampImage {
+	return &stampImage{
+		data:     data,
+		mimeType: http.DetectContentType(data), //拡張子ではなく内容から判定
+		etag:     fmt.Sprintf("\"%x\"", sha256.Sum256(data)),
+	}
+}
+
+// loadStampImages 組み込みのスタンプ画像を読み込む
+func (p *Plugin) loadStampImages() error {
+	bundlePath, err := p.API.GetBundlePath()
+	if err != nil {
+		return errors.Wrap(err, "failed to get bundle path")
+	}
+
+	uc := peerPostUsecase{
+		plugin: p,
+	}
+	images := make(map[string]*stampImage)
+	for _, option := range uc.createBuiltinStampOptions() {
+		data, err := ioutil.ReadFile(filepath.Join(bundlePath, "assets", filepath.FromSlash(option.Value)))
+		if err != nil {
+			return errors.Wrapf(err, "failed to read stamp image %s", option.Value)
+		}
+		images[option.Value] = newStampImage(data)
+	}
+
+	p.stampImagesLock.Lock()
+	defer p.stampImagesLock.Unlock()
+	p.stampImages = images
+
+	return nil
+}
+
+// getStampImage パスに対応するスタンプ画像を取得する（未知のパスの場合はnil）
+func (p *Plugin) getStampImage(path string) *stampImage {
+	p.stampImagesLock.RLock()
+	image, ok := p.stampImages[path]
+	p.stampImagesLock.RUnlock()
+	if ok {
+		return image
+	}
+
+	//管理者が追加したスタンプは初回のみKVストアから取得
+	if !strings.HasPrefix(path, customStampPathPrefix) {
+		return nil
+	}
+	id := strings.TrimPrefix(path, customStampPathPrefix)
+	if !model.IsValidId(id) {
+		return nil
+	}
+	data, appErr := p.getCustomStampImage(id)
+	if appErr != nil {
+		p.API.LogError("Failed to get custom stamp image", "err", appErr.Error())
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+	image = newStampImage(data)
+
+	p.stampImagesLock.Lock()
+	defer p.stampImagesLock.Unlock()
+	if p.stampImages == nil {
+		p.stampImages = make(map[string]*stampImage)
+	}
+	p.stampImages[path] = image
+
+	return image
+}
+
+func isValidStampSize(size int) bool {
+	for _, s := range stampSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// getResizedStampImage 指定のサイズに収まるよう縮小したスタンプ画像を取得する
+// 縮小できない場合（アニメーションGIFなど）は元の画像を返す
+func (p *Plugin) getResizedStampImage(path string, original *stampImage, size int) *stampImage {
+	key := fmt.Sprintf("%s?size=%d", path, size)
+
+	p.stampImagesLock.RLock()
+	resized, ok := p.resizedStampImages[key]
+	p.stampImagesLock.RUnlock()
+	if ok {
+		return resized
+	}
+
+	resized = original
+	if data, err := resizeImage(original.data, size); err != nil {
+		p.API.LogWarn("Failed to resize stamp image", "path", path, "err", err.Error())
+	} else if data != nil {
+		resized = newStampImage(data)
+	}
+
+	p.stampImagesLock.Lock()
+	defer p.stampImagesLock.Unlock()
+	if p.resizedStampImages == nil {
+		p.resizedStampImages = make(map[string]*stampImage)
+	}
+	p.resizedStampImages[key] = resized
+
+	return resized
+}
+
+// resizeImage 縦横比を保ったまま指定のサイズに収まるよう縮小してPNGにする
+// 縮小の必要が無い場合とアニメーションGIFの場合はnilを返す
+func resizeImage(data []byte, size int) ([]byte, error) {
+	if http.DetectContentType(data) == "image/gif" {
+		animation, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if len(animation.Image) > 1 {
+			return nil, nil //アニメーションを保つため縮小しない
+		}
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return nil, nil //拡大はしない
+	}
+	if width >= height {
+		width, height = size, height*size/width
+	} else {
+		width, height = width*size/height, size
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleDown(src, width, height)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown 縮小先の１ピクセルに対応する範囲の平均を取って縮小する
+func scaleDown(src image.Image, width int, height int) *image.NRGBA {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			//透過を考慮して乗算済みの値で平均を取る
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			rgba := color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			}
+			dst.Set(x, y, rgba)
+		}
+	}
+	return dst
+}
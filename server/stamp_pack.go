@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// stampPack 期間やチームを限定して提供するスタンプのまとまり
+type stampPack struct {
+	name    string
+	from    packDate
+	to      packDate
+	teamIDs []string
+	labels  []string
+}
+
+// packDate パックの提供期間の日付
+// 年が0の場合は毎年繰り返す日付（MM/DD）として扱う
+type packDate struct {
+	year  int
+	month int
+	day   int
+}
+
+func (d packDate) isZero() bool {
+	return d.month == 0
+}
+
+// compare 日付を比較する（-1: dより前, 0: 同じ, 1: dより後）
+// 毎年繰り返す日付の場合は月日のみで比較する
+func (d packDate) compare(t time.Time) int {
+	var target, value int
+	if d.year == 0 {
+		target = int(t.Month())*100 + t.Day()
+		value = d.month*100 + d.day
+	} else {
+		target = t.Year()*10000 + int(t.Month())*100 + t.Day()
+		value = d.year*10000 + d.month*100 + d.day
+	}
+	if target < value {
+		return -1
+	} else if target > value {
+		return 1
+	}
+	return 0
+}
+
+// isAvailable 指定の日時、チームでパックを提供するか確認する
+func (s *stampPack) isAvailable(now time.Time, teamID string) bool {
+	if len(s.teamIDs) > 0 && !containsString(s.teamIDs, teamID) {
+		return false
+	}
+
+	afterFrom := s.from.isZero() || s.from.compare(now) >= 0
+	beforeTo := s.to.isZero() || s.to.compare(now) <= 0
+
+	//毎年繰り返す期間が年を跨ぐ場合（ex: 12/25-01/07）
+	if s.from.year == 0 && s.to.year == 0 && !s.from.isZero() && !s.to.isZero() &&
+		s.from.month*100+s.from.day > s.to.month*100+s.to.day {
+		return afterFrom || beforeTo
+	}
+	return afterFrom && beforeTo
+}
+
+// parsePackDate 「YYYY/MM/DD」または「MM/DD」の日付を読み込む（空の場合は期限なし）
+func parsePackDate(value string) (packDate, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return packDate{}, nil
+	}
+
+	layout := "2006/01/02"
+	if strings.Count(value, "/") == 1 {
+		layout = "01/02"
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return packDate{}, errors.Errorf("有効な日付ではありません。（%s）", value)
+	}
+
+	date := packDate{
+		month: int(t.Month()),
+		day:   t.Day(),
+	}
+	if layout == "2006/01/02" {
+		date.year = t.Year()
+	}
+	return date, nil
+}
+
+// readStampPacks スタンプパックを読み込む
+// １行の書式は「パック名|開始日|終了日|チーム名,...|スタンプのラベル,...」
+func (p *Plugin) readStampPacks(configuration *configuration) error {
+	configuration.stampPacks = []*stampPack{}
+
+	for _, line := range strings.Split(configuration.StampPacks, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue //空行はスキップ
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) != 5 {
+			return errors.Errorf("スタンプパックは「パック名|開始日|終了日|チーム名,...|スタンプのラベル,...」の書式で入力してください。（%s）", line)
+		}
+
+		pack := stampPack{
+			name:    strings.TrimSpace(fields[0]),
+			teamIDs: []string{},
+			labels:  splitList(fields[4]),
+		}
+		var err error
+		if pack.from, err = parsePackDate(fields[1]); err != nil {
+			return errors.Wrapf(err, "スタンプパック %s の開始日", pack.name)
+		}
+		if pack.to, err = parsePackDate(fields[2]); err != nil {
+			return errors.Wrapf(err, "スタンプパック %s の終了日", pack.name)
+		}
+		for _, teamName := range splitList(fields[3]) {
+			team, appErr := p.API.GetTeamByName(teamName)
+			if appErr != nil {
+				return errors.Errorf("チームが見つかりません。（%s）", teamName)
+			}
+			pack.teamIDs = append(pack.teamIDs, team.Id)
+		}
+		if len(pack.labels) == 0 {
+			return errors.Errorf("スタンプパックにスタンプのラベルが入力されていません。（%s）", line)
+		}
+
+		configuration.stampPacks = append(configuration.stampPacks, &pack)
+	}
+
+	return nil
+}
+
+// splitList カンマ区切りの値を読み込む
+func splitList(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// isStampAvailable スタンプを提供するか確認する
+// どのパックにも含まれないスタンプは常に提供し、パックに含まれるスタンプはいずれかのパックが提供中の場合のみ提供する
+func (c *configuration) isStampAvailable(label string, now time.Time, teamID string) bool {
+	packed := false
+	for _, pack := range c.stampPacks {
+		if !containsString(pack.labels, label) {
+			continue
+		}
+		if pack.isAvailable(now, teamID) {
+			return true
+		}
+		packed = true
+	}
+	return !packed
+}
+
+// describe パックの名前と期間を表示用に整形する
+func (s *stampPack) describe() string {
+	format := func(d packDate) string {
+		if d.isZero() {
+			return ""
+		} else if d.year == 0 {
+			return strconv.Itoa(d.month) + "/" + strconv.Itoa(d.day)
+		}
+		return strconv.Itoa(d.year) + "/" + strconv.Itoa(d.month) + "/" + strconv.Itoa(d.day)
+	}
+	return s.name + " (" + format(s.from) + "〜" + format(s.to) + ")"
+}
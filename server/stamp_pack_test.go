@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStampPackIsAvailable(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	winter := &stampPack{
+		from: packDate{month: 12, day: 25},
+		to:   packDate{month: 1, day: 7},
+	}
+	april := &stampPack{
+		from: packDate{month: 4, day: 1},
+		to:   packDate{month: 4, day: 30},
+	}
+	newYear2020 := &stampPack{
+		from: packDate{year: 2019, month: 12, day: 25},
+		to:   packDate{year: 2020, month: 1, day: 7},
+	}
+	fromOnly := &stampPack{
+		from: packDate{year: 2019, month: 10, day: 1},
+	}
+	teamOnly := &stampPack{
+		teamIDs: []string{"team1"},
+	}
+
+	tests := []struct {
+		name   string
+		pack   *stampPack
+		now    time.Time
+		teamID string
+		want   bool
+	}{
+		{name: "期間もチームも指定なし", pack: &stampPack{}, now: date(2019, time.June, 1), want: true},
+		{name: "年を跨ぐ期間：開始日", pack: winter, now: date(2019, time.December, 25), want: true},
+		{name: "年を跨ぐ期間：年末", pack: winter, now: date(2019, time.December, 31), want: true},
+		{name: "年を跨ぐ期間：年始", pack: winter, now: date(2020, time.January, 3), want: true},
+		{name: "年を跨ぐ期間：終了日", pack: winter, now: date(2020, time.January, 7), want: true},
+		{name: "年を跨ぐ期間：終了日の翌日", pack: winter, now: date(2020, time.January, 8), want: false},
+		{name: "年を跨ぐ期間：開始日の前日", pack: winter, now: date(2019, time.December, 24), want: false},
+		{name: "年を跨ぐ期間：期間外", pack: winter, now: date(2019, time.June, 1), want: false},
+		{name: "毎年の期間：期間内", pack: april, now: date(2021, time.April, 15), want: true},
+		{name: "毎年の期間：期間外", pack: april, now: date(2021, time.May, 1), want: false},
+		{name: "年を指定した期間：期間内", pack: newYear2020, now: date(2019, time.December, 31), want: true},
+		{name: "年を指定した期間：翌年の同じ日", pack: newYear2020, now: date(2020, time.December, 31), want: false},
+		{name: "開始日のみ：開始日の前", pack: fromOnly, now: date(2019, time.September, 30), want: false},
+		{name: "開始日のみ：開始日の後", pack: fromOnly, now: date(2025, time.January, 1), want: true},
+		{name: "チームを限定：対象のチーム", pack: teamOnly, now: date(2019, time.June, 1), teamID: "team1", want: true},
+		{name: "チームを限定：対象外のチーム", pack: teamOnly, now: date(2019, time.June, 1), teamID: "team2", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.pack.isAvailable(test.now, test.teamID); got != test.want {
+				t.Errorf("isAvailable(%v, %q) = %v, want %v", test.now, test.teamID, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	customStampListKey        = "stamp-list"
+	customStampImageKeyPrefix = "stamp-image-"
+
+	customStampPathPrefix = "/stamp/custom/"
+)
+
+// customStamp 管理者が追加したスタンプ
+// 画像はKVストアに別のキーで保存する
+type customStamp struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+func (s *customStamp) path() string {
+	return customStampPathPrefix + s.ID
+}
+
+func (p *Plugin) getCustomStamps() ([]*customStamp, *model.AppError) {
+	data, appErr := p.API.KVGet(customStampListKey)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	stamps := []*customStamp{}
+	if data == nil {
+		return stamps, nil
+	}
+	if err := json.Unmarshal(data, &stamps); err != nil {
+		return nil, model.NewAppError("getCustomStamps", "peerpost.custom_stamp.unmarshal.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	return stamps, nil
+}
+
+func (p *Plugin) saveCustomStamps(stamps []*customStamp) *model.AppError {
+	data, err := json.Marshal(stamps)
+	if err != nil {
+		return model.NewAppError("saveCustomStamps", "peerpost.custom_stamp.marshal.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	return p.API.KVSet(customStampListKey, data)
+}
+
+func (p *Plugin) getCustomStampImage(id string) ([]byte, *model.AppError) {
+	return p.API.KVGet(customStampImageKeyPrefix + id)
+}
+
+func (p *Plugin) saveCustomStampImage(id string, image []byte) *model.AppError {
+	return p.API.KVSet(customStampImageKeyPrefix+id, image)
+}
+
+// getPostStamp ピア投稿に使われたスタンプのIDを求める
+// スタンプのIDを保存していない古い投稿は、サムネイルのURLから求める
+func (p *Plugin) getPostStamp(post *model.Post) string {
+	if stamp, ok := post.Props["stamp"].(string); ok {
+		return stamp
+	}
+
+	for _, attachment := range post.Attachments() {
+		thumbURL := attachment.ThumbURL
+		if thumbURL == "" {
+			continue
+		}
+		if strings.HasPrefix(thumbURL, emojiImageURLPrefix) {
+			id := strings.TrimSuffix(strings.TrimPrefix(thumbURL, emojiImageURLPrefix), "/image")
+			emoji, err := p.API.GetEmoji(id)
+			if err != nil {
+				p.API.LogWarn("Failed to GetEmoji", "emoji_id", id, "err", err.Error())
+				return ""
+			}
+			return emojiStampPrefix + emoji.Name
+		}
+		path := strings.TrimPrefix(thumbURL, p.getServerHTTPURL(""))
+		if index := strings.Index(path, "?"); index >= 0 {
+			path = path[:index]
+		}
+		return path
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	// userCacheTTL キャッシュしたユーザの有効期間
+	// ユーザの更新やチームの参加・脱退を知らせるフックは無いため、表示名などの変更は有効期間が過ぎてから反映する
+	userCacheTTL = 5 * time.Minute
+
+	userDirectoryPerPage = 200
+	// userDirectoryBulkThreshold キャッシュに無いユーザがこの数以上の場合は、チームのメンバーをまとめて取得する
+	userDirectoryBulkThreshold = 10
+)
+
+// cachedUser キャッシュしたユーザ
+type cachedUser struct {
+	user        *model.User
+	displayName string
+	expiresAt   time.Time
+}
+
+// getUsers ユーザをまとめて取得する
+// キャッシュに無いユーザが多い場合は、１件ずつではなくチームのメンバーをページ単位で取得する
+func (p *Plugin) getUsers(teamID string, userIDs []string) (map[string]*model.User, *model.AppError) {
+	users := map[string]*model.User{}
+	missing := p.lookupCachedUsers(userIDs, users)
+
+	if teamID != "" && len(missing) >= userDirectoryBulkThreshold {
+		for page := 0; ; page++ {
+			teamUsers, err := p.API.GetUsersInTeam(teamID, page, userDirectoryPerPage)
+			if err != nil {
+				return nil, err
+			}
+			p.cacheUsers(teamUsers)
+			if len(teamUsers) < userDirectoryPerPage {
+				break
+			}
+		}
+		missing = p.lookupCachedUsers(missing, users)
+	}
+
+	//チームを抜けたユーザなど、残りは１件ずつ取得する
+	for _, userID := range missing {
+		user, err := p.API.GetUser(userID)
+		if err != nil {
+			return nil, err
+		}
+		p.cacheUsers([]*model.User{user})
+		users[userID] = user
+	}
+
+	return users, nil
+}
+
+// getFreshUsers キャッシュを使わずにユーザを取得する
+// 無効化やBotかどうかの確認など、最新の状態が必要な場合に使う（取得したユーザでキャッシュも更新する）
+func (p *Plugin) getFreshUsers(userIDs []string) (map[string]*model.User, *model.AppError) {
+	users := map[string]*model.User{}
+	for _, userID := range userIDs {
+		if _, ok := users[userID]; ok {
+			continue //重複
+		}
+		user, err := p.API.GetUser(userID)
+		if err != nil {
+			return nil, err
+		}
+		p.cacheUsers([]*model.User{user})
+		users[userID] = user
+	}
+
+	return users, nil
+}
+
+// getUserByID ユーザを取得する（キャッシュがあればキャッシュから）
+func (p *Plugin) getUserByID(userID string) (*model.User, *model.AppError) {
+	users, err := p.getUsers("", []string{userID})
+	if err != nil {
+		return nil, err
+	}
+	return users[userID], nil
+}
+
+// getDisplayNames ユーザの表示名をまとめて取得する
+func (p *Plugin) getDisplayNames(teamID string, userIDs []string) (map[string]string, *model.AppError) {
+	users, err := p.getUsers(teamID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	p.userCacheLock.RLock()
+	defer p.userCacheLock.RUnlock()
+
+	displayNames := map[string]string{}
+	for userID, user := range users {
+		if cached, ok := p.userCache[userID]; ok {
+			displayNames[userID] = cached.displayName
+		} else {
+			displayNames[userID] = formatUserDisplayName(*user)
+		}
+	}
+	return displayNames, nil
+}
+
+// formatUserDisplayName ユーザの表示名を作る
+func formatUserDisplayName(user model.User) string {
+	return user.GetDisplayName(model.SHOW_NICKNAME_FULLNAME)
+}
+
+// lookupCachedUsers キャッシュにあるユーザをusersに追加し、キャッシュに無いユーザのIDを返す
+func (p *Plugin) lookupCachedUsers(userIDs []string, users map[string]*model.User) []string {
+	p.userCacheLock.RLock()
+	defer p.userCacheLock.RUnlock()
+
+	now := time.Now()
+	missing := []string{}
+	for _, userID := range userIDs {
+		if _, ok := users[userID]; ok {
+			continue //重複
+		}
+		cached, ok := p.userCache[userID]
+		if !ok || now.After(cached.expiresAt) {
+			if !containsString(missing, userID) {
+				missing = append(missing, userID)
+			}
+			continue
+		}
+		users[userID] = cached.user
+	}
+	return missing
+}
+
+func (p *Plugin) cacheUsers(users []*model.User) {
+	p.userCacheLock.Lock()
+	defer p.userCacheLock.Unlock()
+
+	if p.userCache == nil {
+		p.userCache = map[string]*cachedUser{}
+	}
+	expiresAt := time.Now().Add(userCacheTTL)
+	for _, user := range users {
+		p.userCache[user.Id] = &cachedUser{
+			user:        user,
+			displayName: formatUserDisplayName(*user),
+			expiresAt:   expiresAt,
+		}
+	}
+}